@@ -0,0 +1,75 @@
+package lb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+)
+
+// BackendTLS configures TLS from the loadbalancer to the backends of an Entry,
+// or to a single Backend which then ignores the settings of its Entry.
+// ServerName is used for SNI and verification and defaults to the host of the
+// backend address. CAFile replaces the system roots and CertFile/KeyFile are a
+// client certificate for backends that require one.
+type BackendTLS struct {
+	Enabled            bool
+	ServerName         string
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool // for testing only
+}
+
+// clientTLSConfig builds the config for a TLS client that trusts the
+// certificates in caFile, or the system roots, and presents the certificate in
+// certFile and keyFile if set
+func clientTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// config returns the tls.Config to dial backends with, nil if TLS is not
+// enabled
+func (t *BackendTLS) config() (*tls.Config, error) {
+	if t == nil || !t.Enabled {
+		return nil, nil
+	}
+	config, err := clientTLSConfig(t.CAFile, t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	config.ServerName = t.ServerName
+	config.InsecureSkipVerify = t.InsecureSkipVerify
+	return config, nil
+}
+
+// backendTLS returns the TLS settings that apply to b in entry
+func backendTLS(entry *Entry, b *Backend) *BackendTLS {
+	if b.TLS != nil {
+		return b.TLS
+	}
+	return entry.BackendTLS
+}
+
+func sameTLS(a, b *BackendTLS) bool {
+	return reflect.DeepEqual(a, b)
+}
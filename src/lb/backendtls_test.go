@@ -0,0 +1,91 @@
+package lb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// tlsReplyServer answers every TLS connection with "ok", clientCA requires
+// client certificates signed by it when set
+func tlsReplyServer(t *testing.T, cert tls.Certificate, clientCA *x509.Certificate) net.Listener {
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if clientCA != nil {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = x509.NewCertPool()
+		config.ClientCAs.AddCert(clientCA)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				if c.(*tls.Conn).Handshake() == nil {
+					c.Write([]byte("ok"))
+				}
+			}()
+		}
+	}()
+	return l
+}
+
+// proxyReply returns what a plain connection through addr reads
+func proxyReply(t *testing.T, addr string) string {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, _ := ioutil.ReadAll(c)
+	return string(reply)
+}
+
+func TestBackendTLS(t *testing.T) {
+	serverCert := testCertificate(t, "backend.example.com")
+	caFile, _ := writeKeyPair(t, t.TempDir(), serverCert)
+	clientCert := testCertificate(t, "lb")
+	clientLeaf, err := x509.ParseCertificate(clientCert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := writeKeyPair(t, t.TempDir(), clientCert)
+
+	backend := tlsReplyServer(t, serverCert, nil)
+	defer backend.Close()
+	mutual := tlsReplyServer(t, serverCert, clientLeaf)
+	defer mutual.Close()
+
+	tests := []struct {
+		name    string
+		addr    string
+		tls     *BackendTLS
+		backend *BackendTLS
+		reply   string
+	}{
+		{"verified", backend.Addr().String(), &BackendTLS{Enabled: true, ServerName: "backend.example.com", CAFile: caFile}, nil, "ok"},
+		{"system roots", backend.Addr().String(), &BackendTLS{Enabled: true, ServerName: "backend.example.com"}, nil, ""},
+		{"wrong name", backend.Addr().String(), &BackendTLS{Enabled: true, ServerName: "other.example.com", CAFile: caFile}, nil, ""},
+		{"client certificate", mutual.Addr().String(), &BackendTLS{Enabled: true, ServerName: "backend.example.com", CAFile: caFile,
+			CertFile: certFile, KeyFile: keyFile}, nil, "ok"},
+		{"no client certificate", mutual.Addr().String(), &BackendTLS{Enabled: true, ServerName: "backend.example.com", CAFile: caFile}, nil, ""},
+		{"backend override", backend.Addr().String(), &BackendTLS{Enabled: true, ServerName: "other.example.com"},
+			&BackendTLS{Enabled: true, ServerName: "backend.example.com", CAFile: caFile}, "ok"},
+	}
+	for _, test := range tests {
+		_, addr := startProxy(t, &Entry{BackendTLS: test.tls, Backends: []*Backend{{Addr: test.addr, TLS: test.backend}}})
+		if reply := proxyReply(t, addr); reply != test.reply {
+			t.Errorf("%s: got %q, want %q", test.name, reply, test.reply)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package lb
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BalancerFactory creates a Balancer for backends, which belong to entry.
+// options holds the BalancerOptions of the Entry and is nil if none were set.
+type BalancerFactory func(entry *Entry, backends []*Backend, options json.RawMessage) (Balancer, error)
+
+var (
+	balancersMux sync.RWMutex
+	balancers    = make(map[string]BalancerFactory)
+)
+
+// RegisterBalancer makes a Balancer available to the Backend field of an
+// Entry. Registering an existing name replaces it.
+func RegisterBalancer(name string, factory BalancerFactory) {
+	if factory == nil {
+		panic("lb: RegisterBalancer factory is nil for " + name)
+	}
+	balancersMux.Lock()
+	defer balancersMux.Unlock()
+	balancers[name] = factory
+}
+
+// BalancerRegistered reports whether a balancer called name exists
+func BalancerRegistered(name string) bool {
+	balancersMux.RLock()
+	defer balancersMux.RUnlock()
+	_, exists := balancers[name]
+	return exists
+}
+
+func newBalancer(entry *Entry, backends []*Backend) (Balancer, error) {
+	balancersMux.RLock()
+	factory, exists := balancers[entry.Backend]
+	balancersMux.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("unsupported balancer '%s'", entry.Backend)
+	}
+	return factory(entry, backends, entry.BalancerOptions)
+}
+
+func init() {
+	RegisterBalancer("RoundRobin", func(entry *Entry, backends []*Backend, options json.RawMessage) (Balancer, error) {
+		return &RoundRobin{Backends: backends}, nil
+	})
+	RegisterBalancer("Hash", func(entry *Entry, backends []*Backend, options json.RawMessage) (Balancer, error) {
+		return &Hash{Backends: backends}, nil
+	})
+	RegisterBalancer("LeastConn", func(entry *Entry, backends []*Backend, options json.RawMessage) (Balancer, error) {
+		return NewLeastConn(backends), nil
+	})
+	RegisterBalancer("WeightedRoundRobin", func(entry *Entry, backends []*Backend, options json.RawMessage) (Balancer, error) {
+		return NewWeightedRoundRobin(backends), nil
+	})
+	RegisterBalancer("ConsistentHash", func(entry *Entry, backends []*Backend, options json.RawMessage) (Balancer, error) {
+		return NewConsistentHash(backends, entry.VirtualNodes, entry.HashKey), nil
+	})
+	RegisterBalancer("Maglev", func(entry *Entry, backends []*Backend, options json.RawMessage) (Balancer, error) {
+		return NewMaglev(backends, entry.TableSize, entry.HashKey), nil
+	})
+	RegisterBalancer("PowerOfTwoChoices", func(entry *Entry, backends []*Backend, options json.RawMessage) (Balancer, error) {
+		return &PowerOfTwoChoices{Backends: backends}, nil
+	})
+	RegisterBalancer("PeakEWMA", func(entry *Entry, backends []*Backend, options json.RawMessage) (Balancer, error) {
+		p := NewPeakEWMA(backends)
+		if options != nil {
+			var o struct {
+				Decay int // seconds
+			}
+			if err := json.Unmarshal(options, &o); err != nil {
+				return nil, fmt.Errorf("PeakEWMA options: %v", err)
+			}
+			if o.Decay > 0 {
+				p.Decay = time.Duration(o.Decay) * time.Second
+			}
+		}
+		return p, nil
+	})
+}
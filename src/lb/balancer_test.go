@@ -0,0 +1,98 @@
+package lb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+// testConn is a client connection that only has a remote address, which is
+// all the hashing balancers look at
+type testConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *testConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func connFrom(addr string) net.Conn {
+	a, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		panic(err)
+	}
+	return &testConn{remote: a}
+}
+
+func testBackends(n int) []*Backend {
+	var backends []*Backend
+	for i := 1; i <= n; i++ {
+		backends = append(backends, &Backend{Addr: fmt.Sprintf("10.0.0.%d:80", i), Weight: 1})
+	}
+	return backends
+}
+
+// firstBackend always picks the first backend, it records the options it was
+// built with
+type firstBackend struct {
+	RoundRobin
+	options json.RawMessage
+}
+
+func (f *firstBackend) NextBackend(c net.Conn) (*Backend, error) {
+	return f.Backends[0], nil
+}
+
+func TestRegisterBalancer(t *testing.T) {
+	if BalancerRegistered("TestFirst") {
+		t.Fatal("TestFirst registered before RegisterBalancer")
+	}
+	RegisterBalancer("TestFirst", func(entry *Entry, backends []*Backend, options json.RawMessage) (Balancer, error) {
+		if string(options) == `"fail"` {
+			return nil, errors.New("bad options")
+		}
+		return &firstBackend{RoundRobin: RoundRobin{Backends: backends}, options: options}, nil
+	})
+	defer func() {
+		balancersMux.Lock()
+		delete(balancers, "TestFirst")
+		balancersMux.Unlock()
+	}()
+	if !BalancerRegistered("TestFirst") {
+		t.Fatal("TestFirst not registered")
+	}
+
+	backends := testBackends(2)
+	balancer, err := newBalancer(&Entry{Backend: "TestFirst", BalancerOptions: json.RawMessage(`{"a": 1}`)}, backends)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(balancer.(*firstBackend).options) != `{"a": 1}` {
+		t.Errorf("factory got options %s", balancer.(*firstBackend).options)
+	}
+	if b, _ := balancer.NextBackend(connFrom("10.1.0.1:1000")); b != backends[0] {
+		t.Errorf("got %v from the registered balancer", b)
+	}
+	if _, err := newBalancer(&Entry{Backend: "TestFirst", BalancerOptions: json.RawMessage(`"fail"`)}, backends); err == nil {
+		t.Error("factory error not returned")
+	}
+	if _, err := newBalancer(&Entry{Backend: "Missing"}, backends); err == nil {
+		t.Error("unknown balancer accepted")
+	}
+}
+
+func TestBuiltinBalancersRegistered(t *testing.T) {
+	for _, name := range []string{"RoundRobin", "Hash", "LeastConn", "WeightedRoundRobin", "ConsistentHash", "Maglev", "PowerOfTwoChoices", "PeakEWMA"} {
+		balancer, err := newBalancer(&Entry{Backend: name}, testBackends(3))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if _, err := balancer.NextBackend(connFrom("10.1.0.1:1000")); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package lb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// configSnapshot is a last known good config, with every file and URL it was
+// read from so that its includes can be resolved without the network
+type configSnapshot struct {
+	Location string
+	Saved    time.Time
+	Sources  []snapshotSource
+}
+
+type snapshotSource struct {
+	Location    string
+	ContentType string
+	Data        []byte
+}
+
+// remote reports whether any part of the config was fetched from a URL
+func (c *Config) remote() bool {
+	for _, s := range c.sources {
+		if httpURL.MatchString(s.location) {
+			return true
+		}
+	}
+	return false
+}
+
+// saveSnapshot writes the sources of config to path, replacing the previous
+// snapshot atomically
+func saveSnapshot(path string, location string, config *Config) error {
+	snapshot := configSnapshot{Location: location, Saved: time.Now()}
+	for _, s := range config.sources {
+		snapshot.Sources = append(snapshot.Sources, snapshotSource{
+			Location:    s.location,
+			ContentType: s.contentType,
+			Data:        s.data,
+		})
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadSnapshot loads the config at location from the snapshot at path, options
+// are those the config was fetched with
+func loadSnapshot(path string, location string, options ConfigOptions) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot configSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if snapshot.Location != location {
+		return nil, fmt.Errorf("%s: cached config is for %s", path, snapshot.Location)
+	}
+
+	f := &configFetcher{options: options, offline: make(map[string]*httpResponse)}
+	for _, s := range snapshot.Sources {
+		f.offline[s.Location] = &httpResponse{contentType: s.ContentType, data: s.Data}
+	}
+	config, err := loadConfig(location, f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	config.Source = fmt.Sprintf("%s (cache of %s saved %s)", path, location, snapshot.Saved.Format(time.RFC3339))
+	return config, nil
+}
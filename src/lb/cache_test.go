@@ -0,0 +1,67 @@
+package lb
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCachedConfigFallback(t *testing.T) {
+	var failing int32
+	listen := closedAddr(t)
+	config := fmt.Sprintf(`{"Entries": [{"ListenAddr": "%s", "Backends": [{"Addr": "127.0.0.1:9000"}]}]}`, listen)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(config))
+	}))
+	defer s.Close()
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+	manager := func() *Manager {
+		fetcher, err := newConfigFetcher(&ConfigOptions{CacheFile: cacheFile}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := &Manager{configFile: s.URL + "/lb.json", signalChan: make(chan os.Signal, 1)}
+		m.watcher = newConfigWatcher(m.configFile, fetcher, m.signalChan)
+		return m
+	}
+
+	// without a cache a failing URL cannot start
+	atomic.StoreInt32(&failing, 1)
+	if _, err := manager().loadConfig(); err == nil {
+		t.Fatal("loaded a config from a failing URL")
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	m := manager()
+	if err := m.reload(); err != nil {
+		t.Fatal(err)
+	}
+	m.stopProxies()
+	if _, err := os.Stat(cacheFile); err != nil {
+		t.Fatalf("config not cached: %v", err)
+	}
+
+	// a running config is kept rather than replaced by the cache
+	atomic.StoreInt32(&failing, 1)
+	if _, err := m.loadConfig(); err == nil {
+		t.Error("running manager fell back to the cache")
+	}
+
+	// at start the cache stands in for the URL
+	cached, err := manager().loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(cached.Source, "cache of "+s.URL) || len(cached.Entries) != 1 || cached.Entries[0].ListenAddr != listen {
+		t.Errorf("got %s with %d entries", cached.Source, len(cached.Entries))
+	}
+}
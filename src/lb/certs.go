@@ -0,0 +1,183 @@
+package lb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CertCheckInterval = 10 * time.Second
+	CertExpiryWarning = 14 * 24 * time.Hour
+)
+
+// keyPair is a certificate loaded from CertFile and KeyFile. It is reloaded
+// when the files change, a pair that does not load keeps the previous
+// certificate in use.
+type keyPair struct {
+	sync.Mutex
+	certFile  string
+	keyFile   string
+	cert      *tls.Certificate
+	leaf      *x509.Certificate
+	state     string // sizes and modification times of the files loaded
+	lastError string
+	warned    time.Time
+}
+
+// CertStatus is the state of a certificate shown in /stats
+type CertStatus struct {
+	CertFile string
+	Subject  string
+	SANs     []string
+	NotAfter time.Time
+	Warning  string `json:",omitempty"`
+	Error    string `json:",omitempty"` // why the files in place were not loaded
+}
+
+func fileState(files ...string) (string, error) {
+	var state []string
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return "", err
+		}
+		state = append(state, fmt.Sprintf("%d@%d", info.Size(), info.ModTime().UnixNano()))
+	}
+	return strings.Join(state, ","), nil
+}
+
+func loadKeyPair(certFile, keyFile string) (*keyPair, error) {
+	k := &keyPair{certFile: certFile, keyFile: keyFile}
+	if err := k.load(); err != nil {
+		return nil, err
+	}
+	k.warnExpiry()
+	return k, nil
+}
+
+// load reads the files and swaps the certificate if they hold a valid pair
+func (k *keyPair) load() error {
+	state, err := fileState(k.certFile, k.keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(k.certFile, k.keyFile)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	k.Lock()
+	k.cert, k.leaf, k.state, k.lastError, k.warned = &cert, leaf, state, "", time.Time{}
+	k.Unlock()
+	return nil
+}
+
+func (k *keyPair) get() *tls.Certificate {
+	if k == nil {
+		return nil
+	}
+	k.Lock()
+	defer k.Unlock()
+	return k.cert
+}
+
+// check reloads the certificate if its files have changed
+func (k *keyPair) check() {
+	if k == nil {
+		return
+	}
+	k.Lock()
+	loaded, lastError := k.state, k.lastError
+	k.Unlock()
+	if state, err := fileState(k.certFile, k.keyFile); err == nil && state == loaded {
+		k.warnExpiry()
+		return
+	}
+
+	if err := k.load(); err != nil {
+		if err.Error() != lastError {
+			logRed(fmt.Sprintf("reloading certificate %s failed, keeping the previous one: %v", k.certFile, err))
+		}
+		k.Lock()
+		k.lastError = err.Error()
+		k.Unlock()
+		return
+	}
+	log.Printf("reloaded certificate %s", k.certFile)
+	k.warnExpiry()
+}
+
+// expiryWarning is set when the certificate has expired or expires within
+// CertExpiryWarning
+func expiryWarning(leaf *x509.Certificate) string {
+	left := time.Until(leaf.NotAfter)
+	if left <= 0 {
+		return "expired"
+	} else if left < CertExpiryWarning {
+		return fmt.Sprintf("expires in %v", left.Round(time.Hour))
+	}
+	return ""
+}
+
+// warnExpiry logs the expiry warning of the certificate once a day
+func (k *keyPair) warnExpiry() {
+	k.Lock()
+	defer k.Unlock()
+	warning := expiryWarning(k.leaf)
+	if warning == "" || time.Since(k.warned) < 24*time.Hour {
+		return
+	}
+	k.warned = time.Now()
+	logYellow(fmt.Sprintf("certificate %s %s", k.certFile, warning))
+}
+
+func (k *keyPair) status() *CertStatus {
+	k.Lock()
+	defer k.Unlock()
+	return &CertStatus{
+		CertFile: k.certFile,
+		Subject:  k.leaf.Subject.String(),
+		SANs:     certNames(k.leaf),
+		NotAfter: k.leaf.NotAfter,
+		Warning:  expiryWarning(k.leaf),
+		Error:    k.lastError,
+	}
+}
+
+func (k *keyPair) MarshalJSON() ([]byte, error) {
+	return json.Marshal(k.status())
+}
+
+// checkCerts reloads the certificates of the pool and its routes that have
+// changed
+func (p *pool) checkCerts() {
+	p.cert.check()
+	for _, r := range p.routes {
+		r.checkCerts()
+	}
+}
+
+// watchCerts checks the certificates of the proxy every CertCheckInterval
+// until it stops
+func (p *Proxy) watchCerts() {
+	ticker := time.NewTicker(CertCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.currentPool().checkCerts()
+		case <-p.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package lb
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// servedName returns the common name of the certificate the listener at addr
+// presents
+func servedName(t *testing.T, addr string) string {
+	c, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	return c.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestCertificateReload(t *testing.T) {
+	live := replyServer(t)
+	defer live.Close()
+	dir := t.TempDir()
+	certFile, keyFile := writeKeyPair(t, dir, testCertificate(t, "one.example.com"))
+	p, addr := startProxy(t, &Entry{CertFile: certFile, KeyFile: keyFile, Backends: []*Backend{{Addr: live.Addr().String()}}})
+	if name := servedName(t, addr); name != "one.example.com" {
+		t.Fatalf("serving %s", name)
+	}
+
+	// the files are replaced in place, the modification time tells them apart
+	writeKeyPair(t, dir, testCertificate(t, "two.example.com"))
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	p.currentPool().checkCerts()
+	if name := servedName(t, addr); name != "two.example.com" {
+		t.Fatalf("serving %s after the files changed", name)
+	}
+
+	// a broken pair keeps the previous certificate
+	if err := ioutil.WriteFile(certFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p.currentPool().checkCerts()
+	if name := servedName(t, addr); name != "two.example.com" {
+		t.Fatalf("serving %s after a failed reload", name)
+	}
+	if status := p.currentPool().cert.status(); status.Error == "" {
+		t.Error("failed reload not reported")
+	}
+}
@@ -0,0 +1,144 @@
+package lb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// ClientAuth configures client certificates on a TLS Entry. Mode is one of
+// "request" (ask for a certificate), "require" (a certificate must be sent),
+// "verify-if-given" (a sent certificate must be signed by CAFile) or "verify"
+// (a certificate signed by CAFile must be sent). When AllowedCNs or
+// AllowedSANs are set the certificate must have one of the subject common
+// names or one of the DNS, email, URI or IP subject alternative names.
+type ClientAuth struct {
+	Mode        string
+	CAFile      string
+	AllowedCNs  []string
+	AllowedSANs []string
+}
+
+var clientAuthModes = map[string]tls.ClientAuthType{
+	"request":         tls.RequestClientCert,
+	"require":         tls.RequireAnyClientCert,
+	"verify-if-given": tls.VerifyClientCertIfGiven,
+	"verify":          tls.RequireAndVerifyClientCert,
+}
+
+// verifies reports whether the mode checks certificates against CAFile
+func (c *ClientAuth) verifies() bool {
+	return c.Mode == "verify" || c.Mode == "verify-if-given"
+}
+
+// serverTLS is the client certificate part of the tls.Config of a listener
+type serverTLS struct {
+	clientAuth tls.ClientAuthType
+	clientCAs  *x509.CertPool
+	allowed    map[string]bool
+}
+
+// compile loads the CA bundle of c, a nil ClientAuth does not ask for client
+// certificates
+func (c *ClientAuth) compile() (*serverTLS, error) {
+	if c == nil {
+		return nil, nil
+	}
+	mode, ok := clientAuthModes[c.Mode]
+	if !ok {
+		return nil, fmt.Errorf("unknown mode '%s'", c.Mode)
+	}
+	s := &serverTLS{clientAuth: mode}
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		s.clientCAs = x509.NewCertPool()
+		if !s.clientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", c.CAFile)
+		}
+	}
+	if len(c.AllowedCNs) > 0 || len(c.AllowedSANs) > 0 {
+		s.allowed = make(map[string]bool)
+		for _, cn := range c.AllowedCNs {
+			s.allowed["CN:"+cn] = true
+		}
+		for _, san := range c.AllowedSANs {
+			s.allowed["SAN:"+san] = true
+		}
+	}
+	return s, nil
+}
+
+// certNames returns the subject alternative names of cert
+func certNames(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+// clientIdentity names the client of a TLS connection by the subject common
+// name of its certificate, or its first subject alternative name. It is empty
+// unless the certificate was verified, anyone can send a certificate with any
+// name to the "request" and "require" modes.
+func clientIdentity(state tls.ConnectionState) string {
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	cert := state.PeerCertificates[0]
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	if names := certNames(cert); len(names) > 0 {
+		return names[0]
+	}
+	return ""
+}
+
+// verifyConnection enforces the allow-lists once the certificate chain has
+// been checked
+func (s *serverTLS) verifyConnection(state tls.ConnectionState) error {
+	if s.allowed == nil {
+		return nil
+	}
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("no client certificate")
+	}
+	cert := state.PeerCertificates[0]
+	if s.allowed["CN:"+cert.Subject.CommonName] {
+		return nil
+	}
+	for _, name := range certNames(cert) {
+		if s.allowed["SAN:"+name] {
+			return nil
+		}
+	}
+	return fmt.Errorf("client certificate '%s' is not allowed", strings.TrimSpace(cert.Subject.String()))
+}
+
+// getConfigForClient returns the config for a TLS handshake with the client
+// certificate settings of the current pool, so that a reload changes them
+// without a new listener
+func (p *Proxy) getConfigForClient(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		s := p.currentPool().serverTLS
+		if s == nil {
+			return nil, nil
+		}
+		config := base.Clone()
+		config.ClientAuth = s.clientAuth
+		config.ClientCAs = s.clientCAs
+		config.VerifyConnection = s.verifyConnection
+		return config, nil
+	}
+}
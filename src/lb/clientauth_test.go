@@ -0,0 +1,27 @@
+package lb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+)
+
+func TestClientIdentity(t *testing.T) {
+	named := &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}, DNSNames: []string{"billing.example.com"}}
+	unnamed := &x509.Certificate{DNSNames: []string{"billing.example.com"}}
+	tests := []struct {
+		state tls.ConnectionState
+		want  string
+	}{
+		{tls.ConnectionState{}, ""},
+		{tls.ConnectionState{PeerCertificates: []*x509.Certificate{named}}, ""},
+		{tls.ConnectionState{PeerCertificates: []*x509.Certificate{named}, VerifiedChains: [][]*x509.Certificate{{named}}}, "billing"},
+		{tls.ConnectionState{PeerCertificates: []*x509.Certificate{unnamed}, VerifiedChains: [][]*x509.Certificate{{unnamed}}}, "billing.example.com"},
+	}
+	for i, test := range tests {
+		if got := clientIdentity(test.state); got != test.want {
+			t.Errorf("%d: got %q, want %q", i, got, test.want)
+		}
+	}
+}
@@ -2,15 +2,104 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"lb"
 )
 
+// configFlags adds the flags for fetching configs from URLs, the returned
+// function builds the options once the flags are parsed
+func configFlags(flags *flag.FlagSet) func() (*lb.ConfigOptions, error) {
+	options := &lb.ConfigOptions{}
+	tokenFile := ""
+	basicAuthFile := ""
+	publicKeyFile := ""
+	flags.StringVar(&tokenFile, "config-token-file", "", "file with a bearer token for fetching the config")
+	flags.StringVar(&basicAuthFile, "config-basic-auth-file", "", "file with user:password for fetching the config")
+	flags.StringVar(&options.CAFile, "config-ca", "", "CA bundle to verify the config server with")
+	flags.StringVar(&options.CertFile, "config-cert", "", "client certificate for fetching the config")
+	flags.StringVar(&options.KeyFile, "config-key", "", "client key for fetching the config")
+	flags.DurationVar(&options.Timeout, "config-timeout", lb.DefaultConfigTimeout, "timeout for fetching the config")
+	flags.StringVar(&publicKeyFile, "config-public-key", "", "ed25519 public key the config must be signed with")
+	flags.StringVar(&options.CacheFile, "config-cache", "", "keep the last good config fetched from a URL in this file and use it when the URL fails at start")
+	flags.BoolVar(&options.ExpandRemote, "config-expand-remote", false, "expand ${VAR} in configs fetched from URLs")
+
+	return func() (*lb.ConfigOptions, error) {
+		if tokenFile != "" {
+			token, err := ioutil.ReadFile(tokenFile)
+			if err != nil {
+				return nil, err
+			}
+			options.BearerToken = strings.TrimSpace(string(token))
+		}
+		if basicAuthFile != "" {
+			auth, err := ioutil.ReadFile(basicAuthFile)
+			if err != nil {
+				return nil, err
+			}
+			user := strings.SplitN(strings.TrimSpace(string(auth)), ":", 2)
+			if len(user) != 2 {
+				return nil, fmt.Errorf("%s: expected user:password", basicAuthFile)
+			}
+			options.Username, options.Password = user[0], user[1]
+		}
+		if publicKeyFile != "" {
+			key, err := lb.LoadPublicKey(publicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			options.PublicKey = key
+		}
+		return options, nil
+	}
+}
+
+// checkConfig loads and validates a config the same way the loadbalancer
+// does, prints it with defaults applied and returns the exit code
+func checkConfig(args []string) int {
+	configFile := ""
+	quiet := false
+
+	flags := flag.NewFlagSet(os.Args[0]+" check-config", flag.ExitOnError)
+	flags.StringVar(&configFile, "config", "", "config")
+	flags.BoolVar(&quiet, "quiet", quiet, "do not print the config")
+	configOptions := configFlags(flags)
+	flags.Parse(args)
+
+	if configFile == "" && flags.NArg() == 1 {
+		configFile = flags.Arg(0)
+	}
+	if configFile == "" {
+		fmt.Fprintln(os.Stderr, "error: no config specified")
+		return 2
+	}
+	options, err := configOptions()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 2
+	}
+
+	config, err := lb.LoadConfigWithOptions(configFile, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", configFile, err)
+		return 1
+	}
+	if !quiet {
+		fmt.Println(config.Dump())
+	}
+	return 0
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "check-config" {
+		os.Exit(checkConfig(os.Args[2:]))
+	}
+
 	configFile := ""
 	pidFile := ""
 	startHTTP := false
@@ -19,11 +108,16 @@ func main() {
 	flags.StringVar(&configFile, "config", "", "config")
 	flags.StringVar(&pidFile, "pid-file", "", "write pid to this file")
 	flags.BoolVar(&startHTTP, "start-http", startHTTP, "start the HTTP server")
+	configOptions := configFlags(flags)
 	flags.Parse(os.Args[1:])
 
 	if configFile == "" {
 		log.Fatal("error: no config specified")
 	}
+	options, err := configOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	if pidFile != "" {
 		if err := ioutil.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0600); err != nil {
@@ -32,7 +126,7 @@ func main() {
 		log.Printf("pid [%v] written to %v", os.Getpid(), pidFile)
 	}
 
-	m := lb.NewManager(configFile)
+	m := lb.NewManagerWithOptions(configFile, options)
 	if startHTTP {
 		go m.HttpServer()
 	}
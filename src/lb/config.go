@@ -4,13 +4,12 @@ import (
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
-	"log"
-	"net/http"
-	"os"
+	"net/url"
+	"path/filepath"
 	"regexp"
-	"syscall"
-	"time"
+	"strings"
 )
 
 const (
@@ -19,96 +18,298 @@ const (
 	CheckInterval  = 10
 )
 
+// Config is the list of entries to proxy. Include lists further configs,
+// paths or globs relative to this one, whose Entries are appended.
+// CheckInterval is the number of seconds between checks of configs that are
+// polled for changes. Source is where the config was loaded from.
 type Config struct {
-	Entries []*Entry
+	Source        string
+	Include       []string `json:",omitempty"`
+	CheckInterval int
+	Entries       []*Entry
+	locations     []location // where each entry was read from, if known
+	sources       []*configSource
+}
+
+// location is the file and line an entry starts on, the file is empty for
+// entries of the top level config
+type location struct {
+	file string
+	line int
 }
 
 type Entry struct {
-	ListenAddr string
-	Type       string
-	Timeout    int
-	Backends   []*Backend
-	Backend    string
-	CertFile   string
-	KeyFile    string
-	Comment    string
+	ListenAddr       string
+	Type             string
+	Timeout          int
+	Backends         []*Backend
+	Backend          string
+	CertFile         string
+	KeyFile          string
+	VirtualNodes     int
+	HashKey          string
+	TableSize        int
+	BalancerOptions  json.RawMessage
+	SlowStart        int // seconds
+	DrainTimeout     int // seconds
+	BackendTLS       *BackendTLS
+	SNIPassthrough   bool // route by the ClientHello without terminating TLS
+	Routes           []*Route
+	ClientAuth       *ClientAuth
+	ProxyProtocol    bool // send a PROXY protocol v2 header to backends
+	AccessLog        bool // log every connection when it ends
+	HealthCheck      *HealthCheck
+	OutlierDetection *OutlierDetection
+	Comment          string
 }
 
-type ReadFunc func(string) ([]byte, error)
+var httpURL = regexp.MustCompile("^http[s]?://.*$")
 
-func getHTTP(url string) ([]byte, error) {
-	r, err := http.Get(url)
-	if err != nil {
-		return []byte{}, err
+// configSource is a config file or URL and its contents. globs are the
+// patterns of its includes.
+type configSource struct {
+	location    string
+	contentType string
+	data        []byte
+	config      *Config
+	globs       []string
+}
+
+// readSource returns the contents of a config file or URL and its Content-Type
+func readSource(location string, f *configFetcher) ([]byte, string, error) {
+	if f != nil && f.offline != nil {
+		r, ok := f.offline[location]
+		if !ok {
+			return nil, "", fmt.Errorf("%s: not cached", location)
+		}
+		return r.data, r.contentType, nil
+	}
+	if httpURL.MatchString(location) {
+		return f.fetch(location)
+	}
+	data, err := ioutil.ReadFile(location)
+	return data, "", err
+}
+
+// includePath returns the path of an Include of the config at base, relative
+// paths are relative to the directory of base
+func includePath(base, include string) string {
+	if httpURL.MatchString(base) || httpURL.MatchString(include) || filepath.IsAbs(include) {
+		return include
+	}
+	return filepath.Join(filepath.Dir(base), include)
+}
+
+// resolveInclude returns the configs matched by an Include of the config at
+// base. Paths are relative to the directory of base and may be globs, URLs are
+// relative to base.
+func resolveInclude(base, include string) ([]string, error) {
+	if httpURL.MatchString(base) {
+		baseURL, err := url.Parse(base)
+		if err != nil {
+			return nil, err
+		}
+		ref, err := url.Parse(include)
+		if err != nil {
+			return nil, err
+		}
+		return []string{baseURL.ResolveReference(ref).String()}, nil
+	}
+	if httpURL.MatchString(include) {
+		return []string{include}, nil
+	}
+
+	include = includePath(base, include)
+	if !strings.ContainsAny(include, "*?[") {
+		return []string{include}, nil
+	}
+	return filepath.Glob(include)
+}
+
+// loadSources reads and parses the config at location and everything it
+// includes, location comes first
+func loadSources(location string, parents []string, f *configFetcher) ([]*configSource, error) {
+	for _, parent := range parents {
+		if parent == location {
+			return nil, fmt.Errorf("include cycle: %s -> %s", strings.Join(parents, " -> "), location)
+		}
 	}
 
-	defer r.Body.Close()
-	data, err := ioutil.ReadAll(r.Body)
+	data, contentType, err := readSource(location, f)
 	if err != nil {
-		return []byte{}, err
+		if len(parents) > 0 && httpURL.MatchString(location) {
+			err = fmt.Errorf("%s: %v", location, err)
+		}
+		return nil, err
+	}
+	// the environment is not exposed to config servers unless asked for
+	expand := !httpURL.MatchString(location) || (f != nil && f.options.ExpandRemote)
+	config, err := parseConfig(data, configFormat(location, contentType), expand)
+	if err != nil {
+		if verr, ok := err.(*ValidationError); ok {
+			verr.File = location
+		} else if len(parents) > 0 {
+			err = fmt.Errorf("%s: %v", location, err)
+		}
+		return nil, err
 	}
 
-	return data, nil
+	source := &configSource{location: location, contentType: contentType, data: data, config: config}
+	sources := []*configSource{source}
+	parents = append(parents[:len(parents):len(parents)], location)
+	for _, include := range config.Include {
+		if !httpURL.MatchString(location) {
+			source.globs = append(source.globs, includePath(location, include))
+		}
+		locations, err := resolveInclude(location, include)
+		if err != nil {
+			return nil, fmt.Errorf("%s: include '%s': %v", location, include, err)
+		}
+		for _, l := range locations {
+			included, err := loadSources(l, parents, f)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, included...)
+		}
+	}
+	return sources, nil
 }
 
-func getHash(b []byte) string {
+// sourcesHash identifies the contents of every file of a config
+func sourcesHash(sources []*configSource) string {
 	hasher := sha1.New()
-	hasher.Write(b)
+	for _, s := range sources {
+		fmt.Fprintf(hasher, "%s\n%d\n", s.location, len(s.data))
+		hasher.Write(s.data)
+	}
 	return base64.URLEncoding.EncodeToString(hasher.Sum(nil))
 }
 
-func checkConfig(url string, original []byte, rf ReadFunc, signalChan chan os.Signal) {
-	hash := getHash(original)
-	for true {
-		time.Sleep(time.Second * CheckInterval)
-		data, err := rf(url)
-		if err == nil {
-			if getHash(data) != hash {
-				break
-			}
-		}
-		// else backoff with sleep???
+// Dump returns the config with defaults applied as indented JSON
+func (c *Config) Dump() string {
+	entries := make([]interface{}, len(c.Entries))
+	for i, e := range c.Entries {
+		entries[i] = plainEntry(e)
 	}
-	log.Printf("config change detected: [%v]", url)
-	signalChan <- syscall.SIGHUP
+	return dumpJSON(struct {
+		*Config
+		Entries []interface{}
+	}{c, entries})
 }
 
-func LoadConfig(path string, signalChan chan os.Signal) (*Config, error) {
-	config := Config{}
-	var data []byte
-	var err error
-	var rf ReadFunc
-	r := regexp.MustCompile("^http[s]?://.*$")
-	if r.MatchString(path) {
-		rf = getHTTP
-	} else {
-		rf = ioutil.ReadFile
-	}
+// LoadConfig reads a JSON, YAML or TOML config and the configs it includes
+// from files or HTTP URLs, applies defaults and validates it
+func LoadConfig(path string) (*Config, error) {
+	return LoadConfigWithOptions(path, nil)
+}
 
-	if data, err = rf(path); err != nil {
+// LoadConfigWithOptions is LoadConfig with options for fetching URLs
+func LoadConfigWithOptions(path string, options *ConfigOptions) (*Config, error) {
+	f, err := newConfigFetcher(options, false)
+	if err != nil {
 		return nil, err
 	}
+	return loadConfig(path, f)
+}
 
-	// start a goroutine that checks for changes to the config
-	go checkConfig(path, data, rf, signalChan)
-
-	err = json.Unmarshal(data, &config)
+func loadConfig(path string, f *configFetcher) (*Config, error) {
+	sources, err := loadSources(path, nil, f)
 	if err != nil {
 		return nil, err
 	}
 
+	config := sources[0].config
+	config.Source = path
+	config.sources = sources
+	for _, s := range sources[1:] {
+		for len(config.locations) < len(config.Entries) {
+			config.locations = append(config.locations, location{})
+		}
+		for i, e := range s.config.Entries {
+			l := location{file: s.location}
+			if i < len(s.config.locations) {
+				l.line = s.config.locations[i].line
+			}
+			config.Entries = append(config.Entries, e)
+			config.locations = append(config.locations, l)
+		}
+	}
+	config.Include = nil
+	if config.CheckInterval == 0 {
+		config.CheckInterval = CheckInterval
+	}
+
 	for _, e := range config.Entries {
+		if e == nil {
+			continue
+		}
 		if e.Timeout == 0 {
 			e.Timeout = DefaultTimeout
 		}
 		if e.Type == "" {
 			e.Type = DefaultType
 		}
-		// TODO error on incorrect backend
+		if e.DrainTimeout == 0 {
+			e.DrainTimeout = DefaultDrainTimeout
+		}
 		if e.Backend == "" {
 			e.Backend = "RoundRobin"
 		}
+		backends := e.Backends
+		for _, r := range e.Routes {
+			if r != nil {
+				backends = append(backends[:len(backends):len(backends)], r.Backends...)
+			}
+		}
+		for _, b := range backends {
+			if b != nil && b.Weight == 0 {
+				b.Weight = 1
+			}
+		}
+		if hc := e.HealthCheck; hc != nil {
+			if hc.Type == "" && e.Type == "udp" {
+				hc.Type = "send-expect"
+			} else if hc.Type == "" {
+				hc.Type = "tcp"
+			}
+			if hc.Interval == 0 {
+				hc.Interval = DefaultHealthInterval
+			}
+			if hc.Timeout == 0 {
+				hc.Timeout = e.Timeout
+			}
+			if hc.Rise == 0 {
+				hc.Rise = DefaultHealthRise
+			}
+			if hc.Fall == 0 {
+				hc.Fall = DefaultHealthFall
+			}
+			if hc.Path == "" {
+				hc.Path = DefaultHealthPath
+			}
+			if hc.ExpectStatus == 0 {
+				hc.ExpectStatus = DefaultHealthExpectStatus
+			}
+		}
+		if od := e.OutlierDetection; od != nil {
+			if od.ConsecutiveFailures == 0 {
+				od.ConsecutiveFailures = DefaultOutlierConsecutiveFailures
+			}
+			if od.BaseEjectionTime == 0 {
+				od.BaseEjectionTime = DefaultOutlierBaseEjectionTime
+			}
+			if od.MaxEjectionTime == 0 {
+				od.MaxEjectionTime = DefaultOutlierMaxEjectionTime
+			}
+			if od.MaxEjectionPercent == 0 {
+				od.MaxEjectionPercent = DefaultOutlierMaxEjectionPercent
+			}
+		}
 	}
 
-	return &config, nil
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
 }
@@ -0,0 +1,135 @@
+package lb
+
+import (
+	"crypto/tls"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strconv"
+)
+
+const (
+	DefaultVirtualNodes = 160
+	DefaultHashKey      = "source-ip"
+)
+
+// hashString is 64 bit FNV-1a followed by the murmur3 finalizer, which spreads
+// similar inputs such as "10.0.0.1:80#1" and "10.0.0.1:80#2" across the ring
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	k := h.Sum64()
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
+
+// connKey returns the part of the connection that is hashed to pick a
+// backend. key is one of "source-ip", "source-ip-port" or "sni", SNI falls
+// back to the source ip when the client did not send a server name.
+func connKey(c net.Conn, key string) (string, error) {
+	if key == "sni" {
+		if sn, ok := c.(serverNamer); ok && sn.ServerName() != "" {
+			return sn.ServerName(), nil
+		}
+		if tc, ok := c.(*tls.Conn); ok {
+			if err := tc.Handshake(); err != nil {
+				return "", err
+			}
+			if name := tc.ConnectionState().ServerName; name != "" {
+				return name, nil
+			}
+		}
+	}
+	if key == "source-ip-port" {
+		return c.RemoteAddr().String(), nil
+	}
+	host, _, err := net.SplitHostPort(c.RemoteAddr().String())
+	return host, err
+}
+
+type ringNode struct {
+	hash    uint64
+	backend *Backend
+}
+
+// ConsistentHash places VirtualNodes points per backend on a hash ring and
+// sends each key to the first available backend clockwise from its hash, so
+// adding or removing a backend only remaps the keys next to its points.
+type ConsistentHash struct {
+	Backends     []*Backend
+	VirtualNodes int
+	Key          string
+	ring         []ringNode
+}
+
+func NewConsistentHash(backends []*Backend, virtualNodes int, key string) *ConsistentHash {
+	if virtualNodes <= 0 {
+		virtualNodes = DefaultVirtualNodes
+	}
+	if key == "" {
+		key = DefaultHashKey
+	}
+
+	ch := ConsistentHash{
+		Backends:     backends,
+		VirtualNodes: virtualNodes,
+		Key:          key,
+		ring:         make([]ringNode, 0, len(backends)*virtualNodes),
+	}
+	for _, b := range backends {
+		for i := 0; i < virtualNodes; i++ {
+			ch.ring = append(ch.ring, ringNode{hashString(b.Addr + "#" + strconv.Itoa(i)), b})
+		}
+	}
+	sort.Slice(ch.ring, func(i, j int) bool {
+		return ch.ring[i].hash < ch.ring[j].hash
+	})
+	return &ch
+}
+
+func (ch *ConsistentHash) NextBackend(c net.Conn) (*Backend, error) {
+	return ch.NextBackendExcluding(c, nil)
+}
+
+// NextBackendExcluding walks the ring past the backends in exclude
+func (ch *ConsistentHash) NextBackendExcluding(c net.Conn, exclude map[*Backend]bool) (*Backend, error) {
+	if len(ch.ring) == 0 {
+		return nil, ErrNoBackend
+	}
+	key, err := connKey(c, ch.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	h := hashString(key)
+	i := sort.Search(len(ch.ring), func(i int) bool {
+		return ch.ring[i].hash >= h
+	})
+	for j := 0; j < len(ch.ring); j++ {
+		if b := ch.ring[(i+j)%len(ch.ring)].backend; usable(b, exclude) {
+			return b, nil
+		}
+	}
+	return nil, ErrNoBackend
+}
+
+func (ch *ConsistentHash) Name() string {
+	return "ConsistentHash"
+}
+
+func (ch *ConsistentHash) Stats() string {
+	return fmt.Sprintf("\nBackends: %v\nKey: %v\nRing size: %v\n", ch.Backends, ch.Key, len(ch.ring))
+}
+
+func (ch *ConsistentHash) HandleStarted(c net.Conn) {
+	// do nothing
+}
+
+func (ch *ConsistentHash) HandleDone(c net.Conn) {
+	// do nothing
+}
@@ -0,0 +1,65 @@
+package lb
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestConnKey(t *testing.T) {
+	tests := []struct {
+		conn net.Conn
+		key  string
+		want string
+	}{
+		{connFrom("10.1.2.3:4567"), "source-ip", "10.1.2.3"},
+		{connFrom("10.1.2.3:4567"), "source-ip-port", "10.1.2.3:4567"},
+		{connFrom("[2001:db8::1]:4567"), "source-ip", "2001:db8::1"},
+		{connFrom("10.1.2.3:4567"), "sni", "10.1.2.3"},
+		{&helloConn{Conn: connFrom("10.1.2.3:4567"), serverName: "a.example.com"}, "sni", "a.example.com"},
+		{&helloConn{Conn: connFrom("10.1.2.3:4567")}, "sni", "10.1.2.3"},
+	}
+	for _, test := range tests {
+		got, err := connKey(test.conn, test.key)
+		if err != nil || got != test.want {
+			t.Errorf("connKey(%v, %s) = %q, %v, want %q", test.conn.RemoteAddr(), test.key, got, err, test.want)
+		}
+	}
+}
+
+func TestConsistentHashRing(t *testing.T) {
+	ch := NewConsistentHash(testBackends(4), 0, "")
+	if len(ch.ring) != 4*DefaultVirtualNodes {
+		t.Fatalf("ring has %d points, want %d", len(ch.ring), 4*DefaultVirtualNodes)
+	}
+	for i := 1; i < len(ch.ring); i++ {
+		if ch.ring[i-1].hash > ch.ring[i].hash {
+			t.Fatal("ring is not sorted")
+		}
+	}
+}
+
+func TestConsistentHashRemoveBackend(t *testing.T) {
+	backends := testBackends(4)
+	before := NewConsistentHash(backends, 0, "")
+	after := NewConsistentHash(append(append([]*Backend{}, backends[:1]...), backends[2:]...), 0, "")
+
+	counts := make(map[*Backend]int)
+	for i := 0; i < 1000; i++ {
+		c := connFrom(fmt.Sprintf("10.%d.%d.1:1000", i/250, i%250))
+		b, _ := before.NextBackend(c)
+		counts[b]++
+		if b == backends[1] {
+			continue
+		}
+		// only the keys of the removed backend move
+		if moved, _ := after.NextBackend(c); moved != b {
+			t.Errorf("%v moved from %s to %s", c.RemoteAddr(), b.Addr, moved.Addr)
+		}
+	}
+	for _, b := range backends {
+		if counts[b] < 150 || counts[b] > 350 {
+			t.Errorf("%s got %d of 1000 keys", b.Addr, counts[b])
+		}
+	}
+}
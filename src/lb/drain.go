@@ -0,0 +1,66 @@
+package lb
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultDrainTimeout = 30
+	DrainLogInterval    = 5
+)
+
+func (p *Proxy) trackConn(c net.Conn) {
+	p.Lock()
+	p.conns[c] = true
+	p.Unlock()
+	atomic.AddInt64(&p.OpenConnections, 1)
+}
+
+func (p *Proxy) untrackConn(c net.Conn) {
+	p.Lock()
+	delete(p.conns, c)
+	p.Unlock()
+	atomic.AddInt64(&p.OpenConnections, -1)
+}
+
+// drain waits for the connections in wg to finish. Once DrainTimeout has
+// passed the remaining client connections are closed, which ends their pipes.
+func (p *Proxy) drain(wg *sync.WaitGroup) {
+	p.setDraining()
+	done := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	deadline := time.After(time.Duration(p.entry().DrainTimeout) * time.Second)
+	ticker := time.NewTicker(DrainLogInterval * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			log.Printf("draining %s: %v connections remaining", p.Listen, atomic.LoadInt64(&p.OpenConnections))
+		case <-deadline:
+			p.Lock()
+			logYellow(fmt.Sprintf("drain timeout for %s: closing %v connections", p.Listen, len(p.conns)))
+			for c := range p.conns {
+				c.Close()
+			}
+			p.Unlock()
+			<-done
+			return
+		}
+	}
+}
+
+// Wait blocks until the proxy has stopped and drained its connections
+func (p *Proxy) Wait() {
+	<-p.done
+}
@@ -0,0 +1,123 @@
+package lb
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// holdServer echoes on every connection until the client closes it
+func holdServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	return l
+}
+
+// openConn connects to addr and waits for its first echo so that the proxy
+// has a backend for it
+func openConn(t *testing.T, addr string) net.Conn {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Write([]byte("hello"))
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(c, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Time{})
+	return c
+}
+
+// waitDone reports whether the proxy stopped within timeout
+func waitDone(p *Proxy, timeout time.Duration) bool {
+	select {
+	case <-p.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestDrainWaitsForConnections(t *testing.T) {
+	l := holdServer(t)
+	defer l.Close()
+	p, addr := startProxy(t, &Entry{Backends: []*Backend{{Addr: l.Addr().String()}}, DrainTimeout: 30})
+	c := openConn(t, addr)
+	p.Close()
+
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Error("new connection accepted while draining")
+	}
+	// the open connection keeps working until it is done
+	c.Write([]byte("again"))
+	if _, err := io.ReadFull(c, make([]byte, 5)); err != nil {
+		t.Fatalf("open connection broken by the drain: %v", err)
+	}
+	if waitDone(p, 200*time.Millisecond) {
+		t.Fatal("stopped with a connection open")
+	}
+	c.Close()
+	if !waitDone(p, 2*time.Second) {
+		t.Fatal("not stopped after the last connection closed")
+	}
+}
+
+func TestDrainTimeoutClosesConnections(t *testing.T) {
+	l := holdServer(t)
+	defer l.Close()
+	p, addr := startProxy(t, &Entry{Backends: []*Backend{{Addr: l.Addr().String()}}, DrainTimeout: 1})
+	c := openConn(t, addr)
+	defer c.Close()
+	start := time.Now()
+	p.Close()
+
+	c.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); err == nil {
+		t.Fatal("connection still open after the drain timeout")
+	}
+	if !waitDone(p, 2*time.Second) {
+		t.Fatal("not stopped after the drain timeout")
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("connection closed after %v, before the drain timeout", elapsed)
+	}
+}
+
+func TestCloseBeforeRun(t *testing.T) {
+	for _, connType := range []string{"tcp", "udp"} {
+		p, err := NewProxy(&Entry{ListenAddr: "127.0.0.1:0", Type: connType, Backend: "RoundRobin", Timeout: 1,
+			Backends: []*Backend{{Addr: "127.0.0.1:1", Weight: 1}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.bind(); err != nil {
+			t.Fatal(err)
+		}
+		p.Close()
+		if p.socket() != nil {
+			t.Errorf("%s: socket still bound after Close", connType)
+		}
+		if !waitDone(p, time.Second) {
+			t.Fatalf("%s: Wait blocks for a proxy closed before Run", connType)
+		}
+		if err := p.Run(); err != nil {
+			t.Errorf("%s: Run after Close: %v", connType, err)
+		}
+	}
+}
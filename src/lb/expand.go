@@ -0,0 +1,125 @@
+package lb
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+var (
+	varReference = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+	jsonNumber   = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+)
+
+// expandString replaces ${VAR} and ${VAR:-default} in s with the value of the
+// environment variable, the default being used when VAR is unset or empty.
+// $$ is a literal $.
+func expandString(s string) (string, error) {
+	var err error
+	expanded := varReference.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$$" {
+			return "$"
+		}
+		m := varReference.FindStringSubmatch(ref)
+		value, set := os.LookupEnv(m[1])
+		if value == "" && m[2] != "" {
+			return m[3]
+		}
+		if !set && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", m[1])
+		}
+		return value
+	})
+	return expanded, err
+}
+
+// fieldType returns the type of the field of struct t that a decoded key
+// sets, matching names without case like encoding/json. It is nil when no
+// field matches.
+func fieldType(t reflect.Type, key string) reflect.Type {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" || f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if strings.EqualFold(name, key) {
+			return f.Type
+		}
+	}
+	return nil
+}
+
+// expandVars expands the variables in every string of a decoded config, t is
+// the type the value is decoded into. A string that is only a variable
+// reference becomes a number or boolean when it sets a numeric or boolean
+// field, so that those can be set from the environment.
+func expandVars(v interface{}, t reflect.Type) (interface{}, error) {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	var err error
+	switch v := v.(type) {
+	case string:
+		expanded, err := expandString(v)
+		if err != nil {
+			return nil, err
+		}
+		if t == nil || varReference.FindString(v) != v || v == "$$" {
+			return expanded, nil
+		}
+		switch t.Kind() {
+		case reflect.Bool:
+			if expanded == "true" || expanded == "false" {
+				return expanded == "true", nil
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			if jsonNumber.MatchString(expanded) {
+				return json.Number(expanded), nil
+			}
+		}
+		return expanded, nil
+	case map[string]interface{}:
+		for key, value := range v {
+			var elem reflect.Type
+			if t != nil && t.Kind() == reflect.Struct {
+				elem = fieldType(t, key)
+			} else if t != nil && t.Kind() == reflect.Map {
+				elem = t.Elem()
+			}
+			if v[key], err = expandVars(value, elem); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			if v[i], err = expandVars(value, sliceElem(t)); err != nil {
+				return nil, err
+			}
+		}
+	case []map[string]interface{}:
+		for _, m := range v {
+			if _, err = expandVars(m, sliceElem(t)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// sliceElem returns the element type of t if it is a slice, raw JSON such as
+// BalancerOptions has none so its strings are left as they are
+func sliceElem(t reflect.Type) reflect.Type {
+	if t != nil && t.Kind() == reflect.Slice && t != reflect.TypeOf(json.RawMessage{}) {
+		return t.Elem()
+	}
+	return nil
+}
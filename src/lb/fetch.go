@@ -0,0 +1,236 @@
+package lb
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const DefaultConfigTimeout = 30 * time.Second
+
+// ConfigOptions configures how configs are fetched from URLs. When PublicKey
+// is set every fetched config must have an Ed25519 signature of its contents
+// at its URL with ".sig" appended to the path, raw or base64 encoded.
+type ConfigOptions struct {
+	BearerToken string
+	Username    string // basic auth, used when set
+	Password    string
+	CAFile      string // CA bundle to verify the config server with
+	CertFile    string // client certificate for mTLS
+	KeyFile     string
+	Timeout     time.Duration // defaults to DefaultConfigTimeout
+	PublicKey   ed25519.PublicKey
+	// CacheFile is where a Manager keeps the last config it applied that was
+	// fetched from a URL, it is used when the config cannot be loaded at start
+	CacheFile string
+	// ExpandRemote expands ${VAR} in configs fetched from URLs too, otherwise
+	// only local files can read the environment
+	ExpandRemote bool
+}
+
+// LoadPublicKey reads an Ed25519 public key, either PEM encoded or the base64
+// encoding of the raw key
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := key.(ed25519.PublicKey); ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("%s: not an ed25519 public key", path)
+	}
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%s: not an ed25519 public key", path)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// httpResponse is the last response for a config URL
+type httpResponse struct {
+	etag         string
+	lastModified string
+	contentType  string
+	data         []byte
+}
+
+// httpCache remembers the last response for each config URL so that checking
+// for changes can use conditional requests, a nil cache is always empty
+type httpCache struct {
+	sync.Mutex
+	responses map[string]*httpResponse
+}
+
+func newHTTPCache() *httpCache {
+	return &httpCache{responses: make(map[string]*httpResponse)}
+}
+
+func (c *httpCache) get(url string) *httpResponse {
+	if c == nil {
+		return nil
+	}
+	c.Lock()
+	defer c.Unlock()
+	return c.responses[url]
+}
+
+func (c *httpCache) put(url string, r *httpResponse) {
+	if c == nil || (r.etag == "" && r.lastModified == "") {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.responses[url] = r
+}
+
+// configFetcher fetches config URLs as configured by ConfigOptions, a nil
+// configFetcher uses the defaults. An offline configFetcher reads every file
+// and URL from a snapshot.
+type configFetcher struct {
+	options ConfigOptions
+	client  *http.Client
+	cache   *httpCache
+	offline map[string]*httpResponse
+}
+
+// newConfigFetcher builds the HTTP client for options, cache enables
+// conditional requests
+func newConfigFetcher(options *ConfigOptions, cache bool) (*configFetcher, error) {
+	f := &configFetcher{}
+	if options != nil {
+		f.options = *options
+	}
+	if cache {
+		f.cache = newHTTPCache()
+	}
+
+	tlsConfig, err := clientTLSConfig(f.options.CAFile, f.options.CertFile, f.options.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := f.options.Timeout
+	if timeout == 0 {
+		timeout = DefaultConfigTimeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	f.client = &http.Client{Transport: transport, Timeout: timeout}
+	return f, nil
+}
+
+func (f *configFetcher) get(url string, cached *httpResponse) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if f.options.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+f.options.BearerToken)
+	} else if f.options.Username != "" {
+		req.SetBasicAuth(f.options.Username, f.options.Password)
+	}
+	if cached != nil && cached.etag != "" {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+	if cached != nil && cached.lastModified != "" {
+		req.Header.Set("If-Modified-Since", cached.lastModified)
+	}
+	return f.client.Do(req)
+}
+
+func readBody(r *http.Response) ([]byte, error) {
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return nil, errors.New(r.Status)
+	}
+	return ioutil.ReadAll(r.Body)
+}
+
+// signatureURL returns the URL of the signature of the config at location.
+// ".sig" is appended to the path so that a query string is kept.
+func signatureURL(location string) (string, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	u.Path += ".sig"
+	if u.RawPath != "" {
+		u.RawPath += ".sig"
+	}
+	return u.String(), nil
+}
+
+// verify checks the signature of a config fetched from location
+func (f *configFetcher) verify(location string, data []byte) error {
+	sigURL, err := signatureURL(location)
+	if err != nil {
+		return err
+	}
+	r, err := f.get(sigURL, nil)
+	if err != nil {
+		return err
+	}
+	sig, err := readBody(r)
+	if err != nil {
+		return fmt.Errorf("signature: %v", err)
+	}
+	if len(sig) != ed25519.SignatureSize {
+		if sig, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig))); err != nil {
+			return fmt.Errorf("signature: %v", err)
+		}
+	}
+	if !ed25519.Verify(f.options.PublicKey, data, sig) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// fetch returns the body of url and its Content-Type. The cached body is
+// returned when the server reports that it has not been modified.
+func (f *configFetcher) fetch(url string) ([]byte, string, error) {
+	if f == nil {
+		f, _ = newConfigFetcher(nil, false)
+	}
+	cached := f.cache.get(url)
+	r, err := f.get(url, cached)
+	if err != nil {
+		return nil, "", err
+	}
+	if r.StatusCode == http.StatusNotModified && cached != nil {
+		r.Body.Close()
+		return cached.data, cached.contentType, nil
+	}
+	data, err := readBody(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if f.options.PublicKey != nil {
+		if err := f.verify(url, data); err != nil {
+			return nil, "", err
+		}
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	f.cache.put(url, &httpResponse{
+		etag:         r.Header.Get("ETag"),
+		lastModified: r.Header.Get("Last-Modified"),
+		contentType:  contentType,
+		data:         data,
+	})
+	return data, contentType, nil
+}
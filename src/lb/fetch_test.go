@@ -0,0 +1,181 @@
+package lb
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSignatureURL(t *testing.T) {
+	tests := map[string]string{
+		"https://example.com/lb.json":                 "https://example.com/lb.json.sig",
+		"https://example.com/lb.json?token=abc&v=2":   "https://example.com/lb.json.sig?token=abc&v=2",
+		"https://example.com/configs/a%2Fb.json?x=1":  "https://example.com/configs/a%2Fb.json.sig?x=1",
+		"https://example.com/lb?format=yaml#fragment": "https://example.com/lb.sig?format=yaml#fragment",
+	}
+	for location, want := range tests {
+		got, err := signatureURL(location)
+		if err != nil || got != want {
+			t.Errorf("%s: got %s %v, want %s", location, got, err, want)
+		}
+	}
+}
+
+// configServer serves config at every path
+func configServer(t *testing.T, config string) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(config))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestRemoteConfigNotExpanded(t *testing.T) {
+	os.Setenv("LB_TEST_SECRET", "secret")
+	defer os.Unsetenv("LB_TEST_SECRET")
+	s := configServer(t, `{"Entries": [{"ListenAddr": "127.0.0.1:8080", "Comment": "${LB_TEST_SECRET}",
+		"Backends": [{"Addr": "127.0.0.1:9000"}]}]}`)
+
+	for _, expand := range []bool{false, true} {
+		f, err := newConfigFetcher(&ConfigOptions{ExpandRemote: expand}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := loadConfig(s.URL+"/lb.json", f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "${LB_TEST_SECRET}"
+		if expand {
+			want = "secret"
+		}
+		if got := config.Entries[0].Comment; got != want {
+			t.Errorf("ExpandRemote %v: got %q, want %q", expand, got, want)
+		}
+	}
+}
+
+func TestFetchAuth(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if r.Header.Get("Authorization") != "Bearer token" && (!ok || user != "user" || pass != "pass") {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("config"))
+	}))
+	defer s.Close()
+
+	tests := []struct {
+		options *ConfigOptions
+		ok      bool
+	}{
+		{nil, false},
+		{&ConfigOptions{BearerToken: "token"}, true},
+		{&ConfigOptions{BearerToken: "wrong"}, false},
+		{&ConfigOptions{Username: "user", Password: "pass"}, true},
+		{&ConfigOptions{Username: "user", Password: "wrong"}, false},
+	}
+	for _, test := range tests {
+		f, err := newConfigFetcher(test.options, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _, err := f.fetch(s.URL)
+		if test.ok && (err != nil || string(data) != "config") {
+			t.Errorf("%+v: got %q %v", test.options, data, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("%+v: fetched without valid credentials", test.options)
+		}
+	}
+}
+
+func TestFetchClientCertificate(t *testing.T) {
+	client := testCertificate(t, "client")
+	leaf, err := x509.ParseCertificate(client.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("config"))
+	}))
+	s.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: x509.NewCertPool()}
+	s.TLS.ClientCAs.AddCert(leaf)
+	s.StartTLS()
+	defer s.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := writeKeyPair(t, dir, client)
+
+	f, err := newConfigFetcher(&ConfigOptions{CAFile: caFile}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := f.fetch(s.URL); err == nil {
+		t.Error("fetched without a client certificate")
+	}
+	f, err = newConfigFetcher(&ConfigOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _, err := f.fetch(s.URL); err != nil || string(data) != "config" {
+		t.Errorf("got %q %v", data, err)
+	}
+}
+
+func TestFetchSignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := []byte("config")
+	sig := ed25519.Sign(private, config)
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signatures := map[string][]byte{
+		"/raw.json.sig":     sig,
+		"/base64.json.sig":  []byte(base64.StdEncoding.EncodeToString(sig) + "\n"),
+		"/other.json.sig":   ed25519.Sign(other, config),
+		"/garbage.json.sig": []byte("not a signature"),
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if filepath.Ext(r.URL.Path) == ".json" {
+			w.Write(config)
+		} else if sig, ok := signatures[r.URL.Path]; ok {
+			w.Write(sig)
+		} else {
+			http.NotFound(w, r)
+		}
+	}))
+	defer s.Close()
+
+	f, err := newConfigFetcher(&ConfigOptions{PublicKey: public}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{"/raw.json": true, "/base64.json": true, "/other.json": false, "/garbage.json": false, "/missing.json": false}
+	for path, ok := range tests {
+		data, _, err := f.fetch(s.URL + path)
+		if ok && (err != nil || string(data) != "config") {
+			t.Errorf("%s: got %q %v", path, data, err)
+		} else if !ok && err == nil {
+			t.Errorf("%s: accepted without a valid signature", path)
+		}
+	}
+}
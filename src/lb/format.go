@@ -0,0 +1,212 @@
+package lb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// configFormat picks the format of a config from the extension of its path
+// or URL, then from the Content-Type it was served with, defaulting to JSON
+func configFormat(location, contentType string) string {
+	if u, err := url.Parse(location); err == nil && u.Scheme != "" && u.Path != "" {
+		location = u.Path
+	}
+	switch strings.ToLower(path.Ext(location)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	case ".json":
+		return FormatJSON
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasSuffix(mediaType, "yaml"):
+		return FormatYAML
+	case strings.HasSuffix(mediaType, "toml"):
+		return FormatTOML
+	}
+	return FormatJSON
+}
+
+// parseConfig decodes data in the given format. Every format is decoded into
+// generic values, has its variables expanded if expand is set and is converted
+// to JSON so that it maps onto Config the same way.
+func parseConfig(data []byte, format string, expand bool) (*Config, error) {
+	var v interface{}
+	var lines []int
+	switch format {
+	case FormatYAML:
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		if err := doc.Decode(&v); err != nil {
+			return nil, err
+		}
+		lines = yamlEntryLines(&doc)
+	case FormatTOML:
+		var m map[string]interface{}
+		if _, err := toml.Decode(string(data), &m); err != nil {
+			return nil, err
+		}
+		v = m
+		lines = tomlEntryLines(data)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err := dec.Decode(&v)
+		if err == nil {
+			if _, err = dec.Token(); err == io.EOF {
+				err = nil
+			} else if err == nil {
+				err = errors.New("invalid data after the config")
+			}
+		}
+		if serr, ok := err.(*json.SyntaxError); ok {
+			return nil, fmt.Errorf("line %d: %v", lineAt(data, int(serr.Offset)), err)
+		} else if err != nil {
+			return nil, err
+		}
+		lines = jsonEntryLines(data)
+	}
+
+	if expand {
+		var err error
+		if v, err = expandVars(v, reflect.TypeOf(Config{})); err != nil {
+			return nil, err
+		}
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	config := Config{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, typeError(err, lines)
+	}
+	for _, line := range lines {
+		config.locations = append(config.locations, location{line: line})
+	}
+	return &config, nil
+}
+
+// typeError names the entry and line of a value of the wrong type, the JSON
+// every format is converted to does not know where the value was read from
+func typeError(err error, lines []int) error {
+	terr, ok := err.(*json.UnmarshalTypeError)
+	if !ok || terr.Field == "" {
+		return err
+	}
+	message := fmt.Sprintf("expected %v, got %s", terr.Type, terr.Value)
+	if parts := strings.SplitN(terr.Field, ".", 3); len(parts) == 3 && strings.EqualFold(parts[0], "Entries") {
+		if i, err := strconv.Atoi(parts[1]); err == nil {
+			verr := &ValidationError{Entry: i, Field: parts[2], Message: message}
+			if i < len(lines) {
+				verr.Line = lines[i]
+			}
+			return verr
+		}
+	}
+	return fmt.Errorf("%s: %s", terr.Field, message)
+}
+
+func lineAt(data []byte, offset int) int {
+	if offset > len(data) {
+		offset = len(data)
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// jsonEntryLines returns the line each element of Entries starts on
+func jsonEntryLines(data []byte) []int {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		if k, _ := key.(string); !strings.EqualFold(k, "Entries") {
+			var skip json.RawMessage
+			if dec.Decode(&skip) != nil {
+				return nil
+			}
+			continue
+		}
+		if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+			return nil
+		}
+		var lines []int
+		for dec.More() {
+			// skip the separator and whitespace after the previous token
+			offset := int(dec.InputOffset())
+			for offset < len(data) && strings.IndexByte(", \t\r\n", data[offset]) >= 0 {
+				offset++
+			}
+			lines = append(lines, lineAt(data, offset))
+			var skip json.RawMessage
+			if dec.Decode(&skip) != nil {
+				return lines
+			}
+		}
+		return lines
+	}
+	return nil
+}
+
+func yamlEntryLines(doc *yaml.Node) []int {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+	m := doc.Content[0]
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if !strings.EqualFold(m.Content[i].Value, "Entries") || m.Content[i+1].Kind != yaml.SequenceNode {
+			continue
+		}
+		var lines []int
+		for _, entry := range m.Content[i+1].Content {
+			lines = append(lines, entry.Line)
+		}
+		return lines
+	}
+	return nil
+}
+
+var tomlEntryHeader = regexp.MustCompile(`(?i)^\s*\[\[\s*"?entries"?\s*\]\]`)
+
+// tomlEntryLines finds the [[Entries]] table headers, entries written as an
+// inline array are not located
+func tomlEntryLines(data []byte) []int {
+	var lines []int
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		if tomlEntryHeader.MatchString(scanner.Text()) {
+			lines = append(lines, n)
+		}
+	}
+	return lines
+}
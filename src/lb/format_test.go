@@ -0,0 +1,83 @@
+package lb
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigVariableTypes(t *testing.T) {
+	os.Setenv("LB_TEST_NUMBER", "123")
+	os.Setenv("LB_TEST_BOOL", "true")
+	defer os.Unsetenv("LB_TEST_NUMBER")
+	defer os.Unsetenv("LB_TEST_BOOL")
+	configs := map[string]string{
+		FormatJSON: `{"Entries": [{"Timeout": "${LB_TEST_NUMBER}", "Comment": "${LB_TEST_NUMBER}", "ProxyProtocol": "${LB_TEST_BOOL}",
+			"HealthCheck": {"Send": "${LB_TEST_BOOL}"}, "Backends": [{"Addr": "${LB_TEST_NUMBER}", "Weight": "${LB_TEST_NUMBER}"}]}]}`,
+		FormatYAML: `entries:
+  - timeout: ${LB_TEST_NUMBER}
+    comment: ${LB_TEST_NUMBER}
+    proxyProtocol: ${LB_TEST_BOOL}
+    healthCheck:
+      send: ${LB_TEST_BOOL}
+    backends:
+      - addr: ${LB_TEST_NUMBER}
+        weight: ${LB_TEST_NUMBER}
+`,
+		FormatTOML: `[[Entries]]
+Timeout = "${LB_TEST_NUMBER}"
+Comment = "${LB_TEST_NUMBER}"
+ProxyProtocol = "${LB_TEST_BOOL}"
+HealthCheck = {Send = "${LB_TEST_BOOL}"}
+Backends = [{Addr = "${LB_TEST_NUMBER}", Weight = "${LB_TEST_NUMBER}"}]
+`,
+	}
+	for format, data := range configs {
+		config, err := parseConfig([]byte(data), format, true)
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		e := config.Entries[0]
+		if e.Timeout != 123 || e.Comment != "123" || !e.ProxyProtocol || e.HealthCheck.Send != "true" ||
+			e.Backends[0].Addr != "123" || e.Backends[0].Weight != 123 {
+			t.Errorf("%s: got %+v %+v %+v", format, e, e.HealthCheck, e.Backends[0])
+		}
+	}
+}
+
+func TestParseConfigTypeErrors(t *testing.T) {
+	configs := map[string]string{
+		FormatJSON: `{"Entries": [
+  {"Timeout": 1},
+  {"Timeout": "slow"}
+]}`,
+		FormatYAML: `entries:
+  - timeout: 1
+  - timeout: slow
+`,
+		FormatTOML: `[[Entries]]
+Timeout = 1
+
+[[Entries]]
+Timeout = "slow"
+`,
+	}
+	lines := map[string]int{FormatJSON: 3, FormatYAML: 3, FormatTOML: 4}
+	for format, data := range configs {
+		_, err := parseConfig([]byte(data), format, true)
+		verr, ok := err.(*ValidationError)
+		if !ok {
+			t.Errorf("%s: got %v", format, err)
+			continue
+		}
+		if verr.Entry != 1 || verr.Line != lines[format] || !strings.EqualFold(verr.Field, "Timeout") {
+			t.Errorf("%s: got %v", format, verr)
+		}
+	}
+
+	_, err := parseConfig([]byte(`{"CheckInterval": "often"}`), FormatJSON, true)
+	if err == nil || err.Error() != "CheckInterval: expected int, got string" {
+		t.Errorf("got %v", err)
+	}
+}
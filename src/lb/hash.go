@@ -9,8 +9,13 @@ type Hash struct {
 	Backends []*Backend
 }
 
-// NOTE currently only works if all backends are up
+// NOTE falls back to the next available backend when the hashed one is
+// down, so clients are remapped as backends go up and down
 func (h *Hash) NextBackend(c net.Conn) (*Backend, error) {
+	return h.NextBackendExcluding(c, nil)
+}
+
+func (h *Hash) NextBackendExcluding(c net.Conn, exclude map[*Backend]bool) (*Backend, error) {
 	// TODO could factor in the port also
 	host, _, err := net.SplitHostPort(c.RemoteAddr().String())
 	if err != nil {
@@ -20,7 +25,12 @@ func (h *Hash) NextBackend(c net.Conn) (*Backend, error) {
 	for _, b := range []byte(net.ParseIP(host)) {
 		i += int(b)
 	}
-	return h.Backends[i%len(h.Backends)], nil
+	for j := 0; j < len(h.Backends); j++ {
+		if b := h.Backends[(i+j)%len(h.Backends)]; usable(b, exclude) {
+			return b, nil
+		}
+	}
+	return nil, ErrNoBackend
 }
 
 func (h *Hash) Name() string {
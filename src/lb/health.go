@@ -0,0 +1,161 @@
+package lb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultHealthInterval     = 5
+	DefaultHealthRise         = 2
+	DefaultHealthFall         = 3
+	DefaultHealthExpectStatus = 200
+	DefaultHealthPath         = "/"
+)
+
+// HealthCheck configures the active checks run against each backend of an
+// Entry. Type is one of "tcp", "http" or "send-expect".
+type HealthCheck struct {
+	Type         string
+	Interval     int // seconds between checks
+	Timeout      int // seconds, defaults to the Entry timeout
+	Rise         int // consecutive passes needed to mark a backend up
+	Fall         int // consecutive failures needed to mark a backend down
+	Path         string
+	ExpectStatus int
+	Send         string
+	Expect       string
+}
+
+type healthChecker struct {
+	config   *HealthCheck
+	network  string
+	backends []*Backend
+	stop     chan bool
+	once     sync.Once
+}
+
+func newHealthChecker(hc *HealthCheck, network string, backends []*Backend) *healthChecker {
+	return &healthChecker{
+		config:   hc,
+		network:  network,
+		backends: backends,
+		stop:     make(chan bool),
+	}
+}
+
+func (h *healthChecker) Start() {
+	for _, b := range h.backends {
+		go h.run(b)
+	}
+}
+
+func (h *healthChecker) Stop() {
+	h.once.Do(func() {
+		close(h.stop)
+	})
+}
+
+func (h *healthChecker) run(b *Backend) {
+	ticker := time.NewTicker(time.Duration(h.config.Interval) * time.Second)
+	defer ticker.Stop()
+
+	passes, failures := 0, 0
+	for {
+		err := h.check(b)
+		select {
+		case <-h.stop:
+			// the backend may already belong to the checks of a reload
+			return
+		default:
+		}
+		if err == nil {
+			passes++
+			failures = 0
+			if !b.Up() && passes >= h.config.Rise {
+				b.setUp(true)
+				b.markRecovered()
+				logGreen(fmt.Sprintf("health check: backend %v is up", b.Addr))
+			}
+		} else {
+			failures++
+			passes = 0
+			if b.Up() && failures >= h.config.Fall {
+				b.setUp(false)
+				logRed(fmt.Sprintf("health check: backend %v is down: %v", b.Addr, err))
+			}
+		}
+
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *healthChecker) check(b *Backend) error {
+	timeout := time.Duration(h.config.Timeout) * time.Second
+	switch h.config.Type {
+	case "tcp":
+		conn, err := net.DialTimeout("tcp", b.Addr, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case "http":
+		client := http.Client{Timeout: timeout}
+		scheme := "http://"
+		if b.tlsConfig != nil {
+			client.Transport = &http.Transport{TLSClientConfig: b.tlsConfig, DisableKeepAlives: true}
+			scheme = "https://"
+		}
+		r, err := client.Get(scheme + b.Addr + h.config.Path)
+		if err != nil {
+			return err
+		}
+		r.Body.Close()
+		if r.StatusCode != h.config.ExpectStatus {
+			return fmt.Errorf("unexpected status %v", r.StatusCode)
+		}
+		return nil
+	case "send-expect":
+		return h.sendExpect(b, timeout)
+	}
+	return errors.New("unknown health check type: " + h.config.Type)
+}
+
+func (h *healthChecker) sendExpect(b *Backend, timeout time.Duration) error {
+	conn, err := b.Dial(h.network, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write([]byte(h.config.Send)); err != nil {
+		return err
+	}
+	if h.config.Expect == "" {
+		return nil
+	}
+
+	var received []byte
+	buffer := make([]byte, 4096)
+	for len(received) < 4096 {
+		n, err := conn.Read(buffer)
+		received = append(received, buffer[:n]...)
+		if bytes.Contains(received, []byte(h.config.Expect)) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return errors.New("expected response not found")
+}
@@ -0,0 +1,140 @@
+package lb
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// echoServer replies to every connection with its first read
+func echoServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			buffer := make([]byte, 64)
+			n, _ := c.Read(buffer)
+			c.Write(buffer[:n])
+			c.Close()
+		}
+	}()
+	return l
+}
+
+func TestHealthCheck(t *testing.T) {
+	live := echoServer(t)
+	defer live.Close()
+	status := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer status.Close()
+	statusAddr := status.Listener.Addr().String()
+	dead := closedAddr(t)
+
+	tests := []struct {
+		check *HealthCheck
+		addr  string
+		pass  bool
+	}{
+		{&HealthCheck{Type: "tcp"}, live.Addr().String(), true},
+		{&HealthCheck{Type: "tcp"}, dead, false},
+		{&HealthCheck{Type: "http", Path: "/ok", ExpectStatus: 200}, statusAddr, true},
+		{&HealthCheck{Type: "http", Path: "/down", ExpectStatus: 200}, statusAddr, false},
+		{&HealthCheck{Type: "http", Path: "/down", ExpectStatus: 503}, statusAddr, true},
+		{&HealthCheck{Type: "send-expect", Send: "ping"}, live.Addr().String(), true},
+		{&HealthCheck{Type: "send-expect", Send: "ping", Expect: "ping"}, live.Addr().String(), true},
+		{&HealthCheck{Type: "send-expect", Send: "ping", Expect: "pong"}, live.Addr().String(), false},
+		{&HealthCheck{Type: "send-expect", Send: "ping"}, dead, false},
+	}
+	for _, test := range tests {
+		test.check.Timeout = 1
+		h := newHealthChecker(test.check, "tcp", nil)
+		err := h.check(&Backend{Addr: test.addr})
+		if (err == nil) != test.pass {
+			t.Errorf("%s %s %s: got %v", test.check.Type, test.check.Path+test.check.Expect, test.addr, err)
+		}
+	}
+}
+
+// waitFor polls cond until it holds or timeout passes
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestHealthCheckMarksBackends(t *testing.T) {
+	l := echoServer(t)
+	addr := l.Addr().String()
+	b := &Backend{Addr: addr}
+	b.setUp(false)
+	h := newHealthChecker(&HealthCheck{Type: "tcp", Interval: 1, Timeout: 1, Rise: 1, Fall: 2}, "tcp", []*Backend{b})
+	h.Start()
+	defer h.Stop()
+
+	if !waitFor(time.Second, b.Up) {
+		t.Fatal("backend not marked up")
+	}
+	l.Close()
+	// Fall is 2 so the first failed check leaves it up
+	time.Sleep(1200 * time.Millisecond)
+	if !b.Up() {
+		t.Fatal("backend marked down after one failed check")
+	}
+	if !waitFor(2*time.Second, func() bool { return !b.Up() }) {
+		t.Fatalf("backend %s not marked down", addr)
+	}
+}
+
+func TestReloadResetsHealth(t *testing.T) {
+	dead := closedAddr(t)
+	entry := &Entry{
+		Backends:    []*Backend{{Addr: dead}},
+		HealthCheck: &HealthCheck{Type: "tcp", Interval: 1, Timeout: 1, Rise: 1, Fall: 1},
+	}
+	p, _ := startProxy(t, entry)
+	b := p.currentPool().backends[0]
+	if !waitFor(time.Second, func() bool { return !b.Up() }) {
+		t.Fatal("backend not marked down")
+	}
+
+	// a changed health check starts over from up, Fall is 3 now so its
+	// first failed check leaves the backend up
+	update := *entry
+	update.HealthCheck = &HealthCheck{Type: "tcp", Interval: 10, Timeout: 1, Rise: 1, Fall: 3}
+	if err := p.Update(&update); err != nil {
+		t.Fatal(err)
+	}
+	if p.currentPool().backends[0] != b {
+		t.Fatal("backend not reused")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if !b.Up() {
+		t.Fatal("backend still down after the health check changed")
+	}
+
+	b.setUp(false)
+	removed := *entry
+	removed.HealthCheck = nil
+	if err := p.Update(&removed); err != nil {
+		t.Fatal(err)
+	}
+	if !b.Up() {
+		t.Fatal("backend still down after the health check was removed")
+	}
+}
@@ -1,99 +1,125 @@
 package lb
 
 import (
-	"errors"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"net"
-	"sort"
 	"sync"
+	"sync/atomic"
 )
 
-type BackendConnection struct {
-	Count   int
-	Backend *Backend
+type leastConnBackend struct {
+	backend *Backend
+	count   int64
 }
 
+// LeastConn sends each connection to the available backend with the fewest
+// connections for its weight. Counts are kept per backend with atomics and the
+// backend of each connection is remembered until HandleDone.
 type LeastConn struct {
 	sync.Mutex
-	Backends          map[string]*BackendConnection
-	ActiveConnections map[string]*BackendConnection
-	Min               int
-	LastIndex         int
+	Backends []*Backend
+	backends atomic.Value // []*leastConnBackend
+	assigned sync.Map     // net.Conn -> *leastConnBackend
+	next     uint32
 }
 
 func NewLeastConn(backends []*Backend) *LeastConn {
-	lc := LeastConn{
-		Backends:          make(map[string]*BackendConnection),
-		ActiveConnections: make(map[string]*BackendConnection),
-	}
+	lc := LeastConn{}
+	lc.SetBackends(backends)
+	return &lc
+}
 
+// SetBackends replaces the backends, the counts of backends that are kept
+// carry over so open connections are still accounted for
+func (lc *LeastConn) SetBackends(backends []*Backend) {
+	lc.Lock()
+	defer lc.Unlock()
+	previous, _ := lc.backends.Load().([]*leastConnBackend)
+	counted := make([]*leastConnBackend, 0, len(backends))
 	for _, b := range backends {
-		lc.Backends[b.Addr] = &BackendConnection{Backend: b}
+		lcb := &leastConnBackend{backend: b}
+		for _, p := range previous {
+			if p.backend == b {
+				lcb = p
+			}
+		}
+		counted = append(counted, lcb)
 	}
-
-	return &lc
+	lc.Backends = backends
+	lc.backends.Store(counted)
 }
 
 func (lc *LeastConn) HandleStarted(c net.Conn) {
 }
 
+// HandleDone releases the connection counted by NextBackend, connections that
+// never got a backend are ignored
 func (lc *LeastConn) HandleDone(c net.Conn) {
-	lc.Lock()
-	lc.ActiveConnections[c.RemoteAddr().String()].Count -= 1
-	delete(lc.ActiveConnections, c.RemoteAddr().String())
-	lc.Unlock()
+	if b, exists := lc.assigned.LoadAndDelete(c); exists {
+		atomic.AddInt64(&b.(*leastConnBackend).count, -1)
+	}
 }
 
 func (lc *LeastConn) NextBackend(c net.Conn) (*Backend, error) {
+	return lc.NextBackendExcluding(c, nil)
+}
 
-	var backend *Backend = nil
-
-	keys := make([]string, 0, len(lc.Backends))
-	for k := range lc.Backends {
-		keys = append(keys, k)
+func (lc *LeastConn) NextBackendExcluding(c net.Conn, exclude map[*Backend]bool) (*Backend, error) {
+	backends := lc.backends.Load().([]*leastConnBackend)
+	if len(backends) == 0 {
+		return nil, ErrNoBackend
 	}
-	sort.Strings(keys)
 
-	for i, j := lc.LastIndex, 0; true; i++ {
-		if i >= len(keys) {
-			i = 0
-		}
-		backendConn := lc.Backends[keys[i]]
-		if backendConn.Count <= lc.Min {
-			backend = backendConn.Backend
-			nextIndex := i + 1
-			if nextIndex >= len(keys) {
-				nextIndex = 0
-			}
-			lc.Lock()
-			lc.LastIndex = nextIndex
-			lc.Unlock()
-			if backendConn.Count < lc.Min {
-				// only break if its less than Min
-				// else keep looping
-				break
-			}
+	// start from a different backend each time so that ties are shared
+	// the modulo is taken in uint32 so the index cannot turn negative where
+	// int is 32 bits
+	start := atomic.AddUint32(&lc.next, 1) % uint32(len(backends))
+	var best *leastConnBackend
+	var bestScore float64
+	for i := range backends {
+		b := backends[(int(start)+i)%len(backends)]
+		if !usable(b.backend, exclude) {
+			continue
 		}
-		j++
-		if j >= len(keys) {
-			break
+		// a backend in slow start looks busier than its count
+		score := float64(atomic.LoadInt64(&b.count)+1) / b.backend.effectiveWeight()
+		if best == nil || score < bestScore {
+			best, bestScore = b, score
 		}
 	}
 
-	if backend == nil {
-		return nil, errors.New("no backend found")
+	if best == nil {
+		return nil, ErrNoBackend
 	}
 
+	atomic.AddInt64(&best.count, 1)
+	// a retry after a failed dial moves the connection to the new backend
+	if previous, exists := lc.assigned.Load(c); exists {
+		atomic.AddInt64(&previous.(*leastConnBackend).count, -1)
+	}
+	lc.assigned.Store(c, best)
+	return best.backend, nil
+}
+
+// MarshalJSON reads the backends under the lock, SetBackends replaces them
+// while the proxy is reloaded
+func (lc *LeastConn) MarshalJSON() ([]byte, error) {
 	lc.Lock()
 	defer lc.Unlock()
-	lc.ActiveConnections[c.RemoteAddr().String()] = lc.Backends[backend.Addr]
-	lc.ActiveConnections[c.RemoteAddr().String()].Count += 1
-	lc.Min = lc.ActiveConnections[c.RemoteAddr().String()].Count
-	return backend, nil
+	return json.Marshal(struct {
+		Backends []*Backend
+	}{lc.Backends})
 }
 
 func (lc *LeastConn) Stats() string {
-	return fmt.Sprintf("\nBackends: %v\nActiveConnections: %v\n", lc.Backends, len(lc.ActiveConnections))
+	var out bytes.Buffer
+	out.WriteString("\n")
+	for _, b := range lc.backends.Load().([]*leastConnBackend) {
+		fmt.Fprintf(&out, "%v connections: %v weight: %.2f\n", b.backend.Addr, atomic.LoadInt64(&b.count), b.backend.effectiveWeight())
+	}
+	return out.String()
 }
 
 func (lc *LeastConn) Name() string {
@@ -0,0 +1,108 @@
+package lb
+
+import (
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLeastConnPicksLeastLoaded(t *testing.T) {
+	backends := testBackends(3)
+	lc := NewLeastConn(backends)
+	var conns []net.Conn
+	for i := 0; i < 6; i++ {
+		c := connFrom("10.1.0.1:1000")
+		if _, err := lc.NextBackend(c); err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, c)
+	}
+	for _, b := range lc.backends.Load().([]*leastConnBackend) {
+		if b.count != 2 {
+			t.Fatalf("%s has %d connections, want 2", b.backend.Addr, b.count)
+		}
+	}
+	// freeing a connection makes its backend the next pick
+	freed, _ := lc.assigned.Load(conns[0])
+	lc.HandleDone(conns[0])
+	if b, _ := lc.NextBackend(connFrom("10.1.0.1:1000")); b != freed.(*leastConnBackend).backend {
+		t.Errorf("got %s, want %s", b.Addr, freed.(*leastConnBackend).backend.Addr)
+	}
+}
+
+func TestLeastConnCounterWraps(t *testing.T) {
+	lc := NewLeastConn(testBackends(3))
+	lc.next = math.MaxUint32 - 2
+	for i := 0; i < 6; i++ {
+		c := connFrom("10.1.0.1:1000")
+		if _, err := lc.NextBackend(c); err != nil {
+			t.Fatal(err)
+		}
+		lc.HandleDone(c)
+	}
+}
+
+// TestLeastConnStress runs NextBackend, retries and HandleDone concurrently,
+// including HandleDone for connections that never got a backend and repeated
+// HandleDone, and checks that every count returns to 0. Run it with -race.
+func TestLeastConnStress(t *testing.T) {
+	backends := testBackends(4)
+	backends[0].Weight = 3
+	lc := NewLeastConn(backends)
+
+	var wg sync.WaitGroup
+	var picked int64
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				c := connFrom("10.2.0.1:1000")
+				switch i % 4 {
+				case 0: // never assigned
+					lc.HandleDone(c)
+				case 1: // retried on another backend
+					lc.NextBackend(c)
+					lc.NextBackend(c)
+					atomic.AddInt64(&picked, 1)
+					lc.HandleDone(c)
+				case 2: // done twice at once
+					lc.NextBackend(c)
+					atomic.AddInt64(&picked, 1)
+					done := make(chan bool)
+					go func() {
+						lc.HandleDone(c)
+						done <- true
+					}()
+					lc.HandleDone(c)
+					<-done
+				default:
+					lc.HandleStarted(c)
+					lc.NextBackend(c)
+					atomic.AddInt64(&picked, 1)
+					lc.HandleDone(c)
+				}
+				if g == 0 && i%50 == 0 {
+					// a reload in the middle keeps the counts
+					lc.SetBackends(backends)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	for _, b := range lc.backends.Load().([]*leastConnBackend) {
+		if n := atomic.LoadInt64(&b.count); n != 0 {
+			t.Errorf("%s has %d connections after all are done", b.backend.Addr, n)
+		}
+	}
+	lc.assigned.Range(func(c, b interface{}) bool {
+		t.Error("connection still assigned")
+		return false
+	})
+	if picked != 32*500*3/4 {
+		t.Errorf("%d connections got a backend", picked)
+	}
+}
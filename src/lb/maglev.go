@@ -0,0 +1,170 @@
+package lb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync/atomic"
+)
+
+const (
+	DefaultTableSize = 65537
+	MaxTableSize     = 655373 // a prime, the table holds an int32 per slot
+)
+
+type maglevTable struct {
+	backends []*Backend
+	entries  []int32
+}
+
+// Maglev is the lookup table hashing described in the Maglev paper. Each
+// backend takes turns filling its preferred slots of the table, which spreads
+// keys almost evenly and remaps few of them when the backends change.
+type Maglev struct {
+	TableSize int
+	Key       string
+	table     atomic.Value // *maglevTable
+}
+
+func NewMaglev(backends []*Backend, tableSize int, key string) *Maglev {
+	if tableSize <= 0 {
+		tableSize = DefaultTableSize
+	} else if tableSize > MaxTableSize {
+		tableSize = MaxTableSize
+	}
+	if key == "" {
+		key = DefaultHashKey
+	}
+	m := Maglev{
+		TableSize: nextPrime(tableSize),
+		Key:       key,
+	}
+	m.table.Store(buildMaglevTable(backends, m.TableSize))
+	return &m
+}
+
+func nextPrime(n int) int {
+	for ; ; n++ {
+		prime := n > 1
+		for i := 2; i*i <= n; i++ {
+			if n%i == 0 {
+				prime = false
+				break
+			}
+		}
+		if prime {
+			return n
+		}
+	}
+}
+
+// buildMaglevTable fills the table in rounds where each backend claims the
+// next free slot of its permutation once per unit of weight
+func buildMaglevTable(backends []*Backend, size int) *maglevTable {
+	t := maglevTable{
+		backends: backends,
+		entries:  make([]int32, size),
+	}
+	if len(backends) == 0 {
+		return &t
+	}
+	for i := range t.entries {
+		t.entries[i] = -1
+	}
+
+	offsets := make([]uint64, len(backends))
+	skips := make([]uint64, len(backends))
+	next := make([]uint64, len(backends))
+	for i, b := range backends {
+		offsets[i] = hashString(b.Addr) % uint64(size)
+		skips[i] = hashString(b.Addr+"#skip")%uint64(size-1) + 1
+	}
+
+	for filled := 0; filled < size; {
+		for i, b := range backends {
+			for w := 0; w < b.weight() && filled < size; w++ {
+				slot := (offsets[i] + next[i]*skips[i]) % uint64(size)
+				for t.entries[slot] >= 0 {
+					next[i]++
+					slot = (offsets[i] + next[i]*skips[i]) % uint64(size)
+				}
+				t.entries[slot] = int32(i)
+				next[i]++
+				filled++
+			}
+		}
+	}
+	return &t
+}
+
+// SetBackends rebuilds the table for a new list of backends. NextBackend
+// keeps using the previous table until the new one is complete.
+func (m *Maglev) SetBackends(backends []*Backend) {
+	m.table.Store(buildMaglevTable(backends, m.TableSize))
+}
+
+// MarshalJSON shows the backends of the current table, SetBackends swaps it
+// while the proxy is reloaded
+func (m *Maglev) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		TableSize int
+		Key       string
+		Backends  []*Backend
+	}{m.TableSize, m.Key, m.table.Load().(*maglevTable).backends})
+}
+
+func (m *Maglev) NextBackend(c net.Conn) (*Backend, error) {
+	return m.NextBackendExcluding(c, nil)
+}
+
+// NextBackendExcluding walks the table past the backends in exclude
+func (m *Maglev) NextBackendExcluding(c net.Conn, exclude map[*Backend]bool) (*Backend, error) {
+	t := m.table.Load().(*maglevTable)
+	if len(t.backends) == 0 {
+		return nil, ErrNoBackend
+	}
+	key, err := connKey(c, m.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	size := uint64(len(t.entries))
+	slot := hashString(key) % size
+	// the following slots belong to the other backends in a pseudo random
+	// order, so the keys of an unavailable backend are spread among the rest
+	for j := uint64(0); j < size; j++ {
+		if b := t.backends[t.entries[(slot+j)%size]]; usable(b, exclude) {
+			return b, nil
+		}
+	}
+	return nil, ErrNoBackend
+}
+
+func (m *Maglev) Name() string {
+	return "Maglev"
+}
+
+func (m *Maglev) Stats() string {
+	t := m.table.Load().(*maglevTable)
+	counts := make([]int, len(t.backends))
+	for _, e := range t.entries {
+		if e >= 0 {
+			counts[e]++
+		}
+	}
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "\nKey: %v\nTable size: %v\n", m.Key, len(t.entries))
+	for i, b := range t.backends {
+		fmt.Fprintf(&out, "%v slots: %v\n", b.Addr, counts[i])
+	}
+	return out.String()
+}
+
+func (m *Maglev) HandleStarted(c net.Conn) {
+	// do nothing
+}
+
+func (m *Maglev) HandleDone(c net.Conn) {
+	// do nothing
+}
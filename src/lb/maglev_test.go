@@ -0,0 +1,108 @@
+package lb
+
+import (
+	"testing"
+)
+
+func TestNextPrime(t *testing.T) {
+	tests := []struct{ n, want int }{
+		{0, 2}, {2, 2}, {4, 5}, {13, 13}, {14, 17}, {65536, 65537},
+	}
+	for _, test := range tests {
+		if got := nextPrime(test.n); got != test.want {
+			t.Errorf("nextPrime(%d) = %d, want %d", test.n, got, test.want)
+		}
+	}
+}
+
+func TestMaglevTableSize(t *testing.T) {
+	tests := []struct{ size, want int }{
+		{0, DefaultTableSize}, {13, 13}, {100, 101}, {MaxTableSize, MaxTableSize}, {1 << 30, MaxTableSize},
+	}
+	for _, test := range tests {
+		if got := NewMaglev(testBackends(2), test.size, "").TableSize; got != test.want {
+			t.Errorf("TableSize %d: got %d, want %d", test.size, got, test.want)
+		}
+	}
+	entry := &Entry{ListenAddr: "127.0.0.1:9000", Type: "tcp", Backend: "Maglev", TableSize: MaxTableSize + 1, Backends: testBackends(1)}
+	errs, ok := (&Config{Entries: []*Entry{entry}}).Validate().(ValidationErrors)
+	if !ok || len(errs) != 1 || errs[0].Field != "TableSize" {
+		t.Errorf("got %v, want a TableSize error", errs)
+	}
+}
+
+func TestMaglevShares(t *testing.T) {
+	tests := []struct {
+		weights []int
+		want    []int // slots per backend
+	}{
+		{[]int{1, 1, 1}, []int{21846, 21846, 21845}},
+		{[]int{2, 1, 1}, []int{32769, 16384, 16384}},
+		{[]int{1}, []int{65537}},
+	}
+	for _, test := range tests {
+		backends := testBackends(len(test.weights))
+		for i, w := range test.weights {
+			backends[i].Weight = w
+		}
+		table := buildMaglevTable(backends, DefaultTableSize)
+		counts := make([]int, len(backends))
+		for _, e := range table.entries {
+			if e < 0 {
+				t.Fatalf("weights %v: empty slot", test.weights)
+			}
+			counts[e]++
+		}
+		for i := range counts {
+			if counts[i] != test.want[i] {
+				t.Errorf("weights %v: slots %v, want %v", test.weights, counts, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestMaglevRemoveBackend(t *testing.T) {
+	backends := testBackends(5)
+	before := buildMaglevTable(backends, DefaultTableSize)
+	after := buildMaglevTable(append(append([]*Backend{}, backends[:2]...), backends[3:]...), DefaultTableSize)
+
+	moved := 0
+	for i := range before.entries {
+		b := before.backends[before.entries[i]]
+		if b == backends[2] {
+			continue
+		}
+		if after.backends[after.entries[i]] != b {
+			moved++
+		}
+	}
+	// the slots of the other backends stay put bar a few
+	if moved > DefaultTableSize/100 {
+		t.Errorf("%d slots of the remaining backends moved", moved)
+	}
+}
+
+func TestMaglevNextBackend(t *testing.T) {
+	backends := testBackends(3)
+	m := NewMaglev(backends, 13, "")
+	c := connFrom("192.168.1.10:5000")
+	first, err := m.NextBackend(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same key always gets the same backend, any source port
+	for _, addr := range []string{"192.168.1.10:5000", "192.168.1.10:6000"} {
+		if b, _ := m.NextBackend(connFrom(addr)); b != first {
+			t.Errorf("%s got %s, want %s", addr, b.Addr, first.Addr)
+		}
+	}
+	// an excluded backend is skipped
+	if b, _ := m.NextBackendExcluding(c, map[*Backend]bool{first: true}); b == first || b == nil {
+		t.Errorf("excluded backend %s picked", first.Addr)
+	}
+	first.setUp(false)
+	if b, _ := m.NextBackend(c); b == first || b == nil {
+		t.Errorf("down backend %s picked", first.Addr)
+	}
+}
@@ -7,13 +7,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"sort"
 	"strings"
+	"sync"
 	"syscall"
-	"time"
 )
 
 const (
@@ -31,14 +32,31 @@ func (p Proxies) Less(i, j int) bool {
 }
 
 type Manager struct {
+	sync.Mutex
 	proxies        Proxies
 	configFile     string
+	config         *Config
+	watcher        *configWatcher
 	doneChan       chan bool
 	attemptingStop bool
+	upgrading      bool
 	signalChan     chan os.Signal
+	httpListener   net.Listener
+	readyOnce      sync.Once
+	handedOff      bool // an upgraded process took over the sockets
 }
 
 func NewManager(configFile string) *Manager {
+	return NewManagerWithOptions(configFile, nil)
+}
+
+// NewManagerWithOptions creates a Manager that fetches its config using
+// options
+func NewManagerWithOptions(configFile string, options *ConfigOptions) *Manager {
+	fetcher, err := newConfigFetcher(options, true)
+	if err != nil {
+		log.Fatal(err)
+	}
 	doneChan := make(chan bool)
 	m := Manager{
 		configFile: configFile,
@@ -46,37 +64,200 @@ func NewManager(configFile string) *Manager {
 		signalChan: make(chan os.Signal),
 	}
 	signal.Notify(m.signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	if upgradeSignal != nil {
+		signal.Notify(m.signalChan, upgradeSignal)
+	}
+	m.watcher = newConfigWatcher(configFile, fetcher, m.signalChan)
 	go m.signalHandler()
-	m.reload()
+	if err := m.reload(); err != nil {
+		log.Fatal(err)
+	}
+	closeInherited()
+	go m.watcher.run()
 	return &m
 }
 
-func (m *Manager) reload() {
-	config, err := LoadConfig(m.configFile, m.signalChan)
+// reload applies the config to the running proxies. Proxies whose entry is
+// unchanged keep running untouched, proxies whose listener is unchanged get
+// their backends swapped in place and the rest are replaced. Nothing is
+// changed if the config or any of its entries fails to load, or if a new
+// listener cannot bind.
+func (m *Manager) reload() error {
+	config, err := m.loadConfig()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	m.proxies = nil
-	proxies := make([]*Proxy, 0)
-	for _, c := range config.Entries {
-		p := NewProxy(c)
+
+	m.Lock()
+	existing := make(map[string]*Proxy)
+	for _, p := range m.proxies {
+		existing[p.Type+p.Listen] = p
+	}
+	m.Unlock()
+
+	proxies := make(Proxies, 0)
+	created := make(Proxies, 0)
+	stale := make(Proxies, 0)
+	updates := make(map[*Proxy]*pool)
+	for _, e := range config.Entries {
+		p, exists := existing[e.Type+e.ListenAddr]
+		delete(existing, e.Type+e.ListenAddr)
+		if exists && !listenerChanged(p.entry(), e) {
+			if entryConfig(p.entry()) != entryConfig(e) {
+				pool, err := p.prepare(e)
+				if err != nil {
+					return fmt.Errorf("%s: %v", e.ListenAddr, err)
+				}
+				updates[p] = pool
+			}
+			proxies = append(proxies, p)
+			continue
+		}
+		if exists {
+			stale = append(stale, p)
+		}
+		p, err := NewProxy(e)
+		if err != nil {
+			return fmt.Errorf("%s: %v", e.ListenAddr, err)
+		}
 		proxies = append(proxies, p)
+		created = append(created, p)
+	}
+	for _, p := range existing {
+		stale = append(stale, p)
 	}
+
+	// bind the new listeners while the old ones keep serving, a new proxy on
+	// the address of an old one takes over a copy of its socket. Nothing has
+	// changed if any of them fails to bind.
+	listening := make(map[string]*Proxy)
+	for _, p := range stale {
+		listening[p.Type+p.Listen] = p
+	}
+	late := make(Proxies, 0)
+	for _, p := range created {
+		if old, exists := listening[p.Type+p.Listen]; exists {
+			if !p.copySocket(old) {
+				// the old socket has to close before its address is free
+				late = append(late, p)
+			}
+			continue
+		}
+		if err = p.bind(); err != nil {
+			err = fmt.Errorf("%s: %v", p.Listen, err)
+			break
+		}
+	}
+	if err != nil {
+		for _, p := range created {
+			p.unbind()
+		}
+		return err
+	}
+
+	// draining carries on in the background
+	for _, p := range stale {
+		log.Println("attempting to shut down:", p.Listen)
+		if err := p.Close(); err != nil {
+			log.Println(err.Error())
+		}
+	}
+	for _, p := range late {
+		if err = p.bind(); err != nil {
+			err = fmt.Errorf("%s: %v", p.Listen, err)
+			break
+		}
+	}
+	if err != nil {
+		for _, p := range created {
+			p.unbind()
+		}
+		m.restore(stale)
+		return err
+	}
+
+	for p, pool := range updates {
+		log.Println("updating:", p.Listen)
+		p.swap(pool)
+	}
+
+	m.Lock()
 	m.proxies = proxies
+	m.config = config
+	m.Unlock()
+	m.watcher.setConfig(config)
+	log.Println("config in effect:", config.Source)
+
+	cacheFile := m.watcher.fetcher.options.CacheFile
+	if cacheFile != "" && config.Source == m.configFile && config.remote() {
+		if err := saveSnapshot(cacheFile, m.configFile, config); err != nil {
+			logRed("failed to cache config: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// restore replaces the stopped proxies with new ones for the same entries
+// after a reload failed to bind a socket it could not copy, Run starts them
+func (m *Manager) restore(stopped Proxies) {
+	m.Lock()
+	defer m.Unlock()
+	for i, p := range m.proxies {
+		for _, s := range stopped {
+			if p != s {
+				continue
+			}
+			restored, err := NewProxy(p.entry())
+			if err == nil {
+				err = restored.bind()
+			}
+			if err != nil {
+				logRed(fmt.Sprintf("failed to restore %s: %v", p.Listen, err))
+				continue
+			}
+			log.Println("restored:", p.Listen)
+			m.proxies[i] = restored
+		}
+	}
 }
 
+// loadConfig loads the config, falling back to the cached last known good
+// config when it cannot be loaded at start. A running config is kept instead.
+func (m *Manager) loadConfig() (*Config, error) {
+	config, err := loadConfig(m.configFile, m.watcher.fetcher)
+	cacheFile := m.watcher.fetcher.options.CacheFile
+	m.Lock()
+	running := m.config != nil
+	m.Unlock()
+	if err == nil || running || cacheFile == "" {
+		return config, err
+	}
+
+	cached, cacheErr := loadSnapshot(cacheFile, m.configFile, m.watcher.fetcher.options)
+	if cacheErr != nil {
+		if !os.IsNotExist(cacheErr) {
+			logRed("failed to load cached config: " + cacheErr.Error())
+		}
+		return nil, err
+	}
+	logYellow(fmt.Sprintf("failed to load %s, using the cached config: %v", m.configFile, err))
+	return cached, nil
+}
+
+// stopProxies stops accepting on every proxy and waits for them to drain
 func (m *Manager) stopProxies() {
-	for _, p := range m.proxies {
+	m.Lock()
+	proxies := m.proxies
+	m.Unlock()
+	for _, p := range proxies {
 		log.Println("attempting to shut down:", p.Listen)
 		if err := p.Close(); err != nil {
-			log.Fatal(err.Error())
-		} else {
-			// TODO handle open connections
-			for !p.Stopped {
-				time.Sleep(2 * time.Millisecond)
-			}
+			log.Println(err.Error())
 		}
 	}
+	for _, p := range proxies {
+		p.Wait()
+	}
 }
 
 func (m *Manager) signalHandler() {
@@ -84,9 +265,17 @@ func (m *Manager) signalHandler() {
 		receivedSignal := <-m.signalChan
 		log.Println("received signal:", receivedSignal)
 		if receivedSignal == syscall.SIGHUP {
-			m.stopProxies()
-			m.reload()
+			if err := m.reload(); err != nil {
+				logRed("reload failed, keeping the running config: " + err.Error())
+			}
+			// starts the new proxies, or the ones restored after a failure
 			m.Run()
+		} else if upgradeSignal != nil && receivedSignal == upgradeSignal {
+			go func() {
+				if err := m.Upgrade(); err != nil {
+					logRed("upgrade failed: " + err.Error())
+				}
+			}()
 		} else if receivedSignal == syscall.SIGTERM || receivedSignal == syscall.SIGINT {
 			log.Println("attempting to stop: pid = ", os.Getpid())
 			if m.attemptingStop { // useful if connections enter a CLOSE_WAIT state
@@ -103,14 +292,24 @@ func (m *Manager) signalHandler() {
 	}
 }
 
+// Run starts the proxies that are not running yet
 func (m *Manager) Run() {
+	m.Lock()
+	defer m.Unlock()
 	for _, proxy := range m.proxies {
+		if proxy.started {
+			continue
+		}
+		proxy.started = true
 		go func(p *Proxy) {
 			if err := p.Run(); err != nil {
 				log.Println(err.Error())
 			}
 		}(proxy)
 	}
+	m.readyOnce.Do(func() {
+		go m.notifyReady()
+	})
 }
 
 func (m *Manager) Wait() bool {
@@ -150,10 +349,21 @@ func (m *Manager) HttpServer() {
 			}
 		case "/stats":
 			w.Header().Set("Content-Type", "application/json")
-			fmt.Fprintf(w, m.Stats())
+			fmt.Fprint(w, m.Stats())
 		case "/config":
 			w.Header().Set("Content-Type", "application/json")
-			fmt.Fprintf(w, m.DumpConfig())
+			fmt.Fprint(w, m.DumpConfig())
+		case "/upgrade":
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			go func() {
+				if err := m.Upgrade(); err != nil {
+					logRed("upgrade failed: " + err.Error())
+				}
+			}()
+			fmt.Fprintln(w, "upgrade started")
 		default:
 			if strings.HasPrefix(r.URL.Path, "/static/") {
 				ServeResource(w, r)
@@ -163,15 +373,33 @@ func (m *Manager) HttpServer() {
 		}
 	})
 
-	log.Fatal(http.ListenAndServe(HTTPListenAddr, nil))
+	listener, err := listenSocket("tcp", HTTPListenAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	m.Lock()
+	m.httpListener = listener
+	m.Unlock()
+	err = http.Serve(listener, nil)
+	m.Lock()
+	handedOff := m.handedOff
+	m.Unlock()
+	if !handedOff {
+		log.Fatal(err)
+	}
+	log.Println("http server handed off")
 }
 
+// DumpConfig returns the config in effect
 func (m *Manager) DumpConfig() string {
-	config, _ := LoadConfig(m.configFile, nil) // TODO handle error
-	return dumpJSON(config)
+	m.Lock()
+	defer m.Unlock()
+	return m.config.Dump()
 }
 
 func (m *Manager) Stats() string {
+	m.Lock()
+	defer m.Unlock()
 	sort.Sort(m.proxies)
 	return dumpJSON(m.proxies)
 }
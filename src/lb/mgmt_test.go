@@ -0,0 +1,167 @@
+package lb
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testManager returns a Manager for the config in path without signal
+// handling or a running watcher
+func testManager(t *testing.T, path string) *Manager {
+	fetcher, err := newConfigFetcher(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Manager{configFile: path, signalChan: make(chan os.Signal, 1)}
+	m.watcher = newConfigWatcher(path, fetcher, m.signalChan)
+	return m
+}
+
+func writeConfig(t *testing.T, path, listen, backend string) {
+	config := fmt.Sprintf(`{"Entries": [{"ListenAddr": "%s", "Type": "tcp", "Backend": "RoundRobin", "Timeout": 1, "Backends": [{"Addr": "%s", "Weight": 1}]}]}`, listen, backend)
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadBindFailureRestores(t *testing.T) {
+	live := replyServer(t)
+	defer live.Close()
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	listen := closedAddr(t)
+	writeConfig(t, path, listen, live.Addr().String())
+	m := testManager(t, path)
+	if err := m.reload(); err != nil {
+		t.Fatal(err)
+	}
+	m.Run()
+	defer m.stopProxies()
+
+	// moving the listener to an address in use fails and keeps the old one
+	writeConfig(t, path, taken.Addr().String(), live.Addr().String())
+	if err := m.reload(); err == nil {
+		t.Fatal("reload succeeded with an address in use")
+	}
+	m.Run()
+	if len(m.proxies) != 1 || m.proxies[0].Listen != listen {
+		t.Fatalf("proxies after a failed reload: %v", m.proxies)
+	}
+	m.proxies[0].WaitListening()
+	c, err := net.Dial("tcp", listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	reply, _ := ioutil.ReadAll(c)
+	if string(reply) != "ok" {
+		t.Fatalf("got %q from the restored listener", reply)
+	}
+}
+
+// udpPing sends a packet to addr and reports whether it was echoed
+func udpPing(t *testing.T, addr string) bool {
+	c, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("ping"))
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	reply := make([]byte, 4)
+	n, err := c.Read(reply)
+	return err == nil && string(reply[:n]) == "ping"
+}
+
+func TestReloadKeepsSocketsUntilBound(t *testing.T) {
+	live := replyServer(t)
+	defer live.Close()
+	echo := udpEchoServer(t)
+	defer echo.Close()
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	free, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	udpListen := free.LocalAddr().String()
+	free.Close()
+
+	dir := t.TempDir()
+	certFile, keyFile := writeKeyPair(t, dir, testCertificate(t, "localhost"))
+	path := filepath.Join(dir, "config.json")
+	tcpListen := closedAddr(t)
+	write := func(tcp, tls, udp string) {
+		config := fmt.Sprintf(`{"Entries": [
+			{"ListenAddr": "%s", "Type": "tcp", "Backend": "RoundRobin", "Timeout": 1, %s "Backends": [{"Addr": "%s", "Weight": 1}]},
+			{"ListenAddr": "%s", "Type": "udp", "Backend": "RoundRobin", "Timeout": 1, "Backends": [{"Addr": "%s", "Weight": 1}]}]}`,
+			tcp, tls, live.Addr(), udp, echo.LocalAddr())
+		if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(tcpListen, "", udpListen)
+	m := testManager(t, path)
+	if err := m.reload(); err != nil {
+		t.Fatal(err)
+	}
+	m.Run()
+	defer m.stopProxies()
+	running := append(Proxies{}, m.proxies...)
+	for _, p := range running {
+		p.WaitListening()
+	}
+
+	// the UDP listener moves and a new TCP one cannot bind, so the old ones
+	// are not closed
+	tlsOptions := fmt.Sprintf(`"CertFile": "%s", "KeyFile": "%s",`, certFile, keyFile)
+	write(taken.Addr().String(), tlsOptions, closedAddr(t))
+	if err := m.reload(); err == nil {
+		t.Fatal("reload succeeded with an address in use")
+	}
+	m.Run()
+	for i, p := range m.proxies {
+		if p != running[i] || p.isDraining() {
+			t.Fatalf("%s replaced or closed by a failed reload", p.Listen)
+		}
+	}
+	if !udpPing(t, udpListen) {
+		t.Fatal("UDP listener not kept after a failed reload")
+	}
+
+	// TLS on the same address takes over a copy of the old socket
+	write(tcpListen, tlsOptions, udpListen)
+	if err := m.reload(); err != nil {
+		t.Fatal(err)
+	}
+	m.Run()
+	if !running[0].isDraining() {
+		t.Fatal("old TCP listener not closed")
+	}
+	m.proxies[0].WaitListening()
+	c, err := tls.Dial("tcp", tcpListen, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if reply, _ := ioutil.ReadAll(c); string(reply) != "ok" {
+		t.Fatalf("got %q from the new listener", reply)
+	}
+	if !udpPing(t, udpListen) {
+		t.Fatal("UDP listener lost on reload")
+	}
+}
@@ -0,0 +1,92 @@
+package lb
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultOutlierConsecutiveFailures = 5
+	DefaultOutlierBaseEjectionTime    = 30
+	DefaultOutlierMaxEjectionTime     = 300
+	DefaultOutlierMaxEjectionPercent  = 50
+)
+
+// OutlierDetection configures the passive ejection of backends that fail to
+// accept or serve connections. A backend is ejected for BaseEjectionTime
+// seconds after ConsecutiveFailures failures in a row, doubling each time it
+// is ejected again up to MaxEjectionTime.
+type OutlierDetection struct {
+	ConsecutiveFailures int
+	BaseEjectionTime    int
+	MaxEjectionTime     int
+	MaxEjectionPercent  int
+}
+
+type outlierDetector struct {
+	sync.Mutex
+	config   *OutlierDetection
+	backends []*Backend
+}
+
+func newOutlierDetector(od *OutlierDetection, backends []*Backend) *outlierDetector {
+	return &outlierDetector{config: od, backends: backends}
+}
+
+func (o *outlierDetector) failure(b *Backend) {
+	if o == nil {
+		return
+	}
+	o.Lock()
+	defer o.Unlock()
+	b.outlier.Lock()
+	defer b.outlier.Unlock()
+
+	b.failures++
+	if b.failures < o.config.ConsecutiveFailures || b.Ejected() {
+		return
+	}
+
+	ejected := 0
+	for _, backend := range o.backends {
+		if backend.Ejected() {
+			ejected++
+		}
+	}
+	if (ejected+1)*100 > o.config.MaxEjectionPercent*len(o.backends) {
+		logYellow(fmt.Sprintf("outlier detection: not ejecting %v, %v%% of backends already ejected",
+			b.Addr, ejected*100/len(o.backends)))
+		return
+	}
+
+	ejectionTime := time.Duration(o.config.BaseEjectionTime) * time.Second
+	maxEjectionTime := time.Duration(o.config.MaxEjectionTime) * time.Second
+	for i := 0; i < b.ejections && ejectionTime < maxEjectionTime; i++ {
+		ejectionTime *= 2
+	}
+	if ejectionTime > maxEjectionTime {
+		ejectionTime = maxEjectionTime
+	}
+
+	b.ejections++
+	b.failures = 0
+	atomic.StoreInt64(&b.ejectedUntil, time.Now().Add(ejectionTime).UnixNano())
+	logRed(fmt.Sprintf("outlier detection: ejecting %v for %v", b.Addr, ejectionTime))
+}
+
+func (o *outlierDetector) success(b *Backend) {
+	if o == nil {
+		return
+	}
+	b.outlier.Lock()
+	defer b.outlier.Unlock()
+
+	b.failures = 0
+	// forget previous ejections once the backend has behaved for a while
+	maxEjectionTime := time.Duration(o.config.MaxEjectionTime) * time.Second
+	if b.ejections > 0 && time.Now().UnixNano() > atomic.LoadInt64(&b.ejectedUntil)+int64(maxEjectionTime) {
+		b.ejections = 0
+	}
+}
@@ -0,0 +1,64 @@
+package lb
+
+import (
+	"sync"
+	"testing"
+)
+
+func testOutlierDetection() *OutlierDetection {
+	return &OutlierDetection{ConsecutiveFailures: 3, BaseEjectionTime: 30, MaxEjectionTime: 300, MaxEjectionPercent: 100}
+}
+
+func TestOutlierEjection(t *testing.T) {
+	backends := testBackends(2)
+	o := newOutlierDetector(testOutlierDetection(), backends)
+	o.failure(backends[0])
+	o.failure(backends[0])
+	o.success(backends[0])
+	o.failure(backends[0])
+	o.failure(backends[0])
+	if backends[0].Ejected() {
+		t.Fatal("ejected although a success reset its failures")
+	}
+	o.failure(backends[0])
+	if !backends[0].Ejected() || backends[1].Ejected() {
+		t.Fatal("not ejected after consecutive failures")
+	}
+}
+
+func TestOutlierMaxEjectionPercent(t *testing.T) {
+	backends := testBackends(2)
+	od := testOutlierDetection()
+	od.MaxEjectionPercent = 50
+	o := newOutlierDetector(od, backends)
+	for i := 0; i < od.ConsecutiveFailures; i++ {
+		o.failure(backends[0])
+		o.failure(backends[1])
+	}
+	if !backends[0].Ejected() || backends[1].Ejected() {
+		t.Fatal("more than MaxEjectionPercent of the backends ejected")
+	}
+}
+
+// the pools before and after a reload share their backends, connections of
+// both count failures at the same time
+func TestOutlierSharedBackends(t *testing.T) {
+	backends := testBackends(2)
+	od := &OutlierDetection{ConsecutiveFailures: 1000000, BaseEjectionTime: 30, MaxEjectionTime: 300, MaxEjectionPercent: 100}
+	old, current := newOutlierDetector(od, backends), newOutlierDetector(od, backends)
+	wg := sync.WaitGroup{}
+	for _, o := range []*outlierDetector{old, current} {
+		wg.Add(1)
+		go func(o *outlierDetector) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				o.failure(backends[0])
+			}
+			o.success(backends[1])
+		}(o)
+	}
+	wg.Wait()
+	if backends[0].failures != 2000 {
+		t.Fatalf("got %d failures, want 2000", backends[0].failures)
+	}
+}
@@ -0,0 +1,80 @@
+package lb
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync/atomic"
+)
+
+// PowerOfTwoChoices picks two available backends at random and uses the one
+// with fewer active connections for its weight
+type PowerOfTwoChoices struct {
+	Backends []*Backend
+}
+
+// randomAvailable returns a random available backend that is not in exclude,
+// or nil if there is none
+func randomAvailable(backends []*Backend, exclude map[*Backend]bool) *Backend {
+	if len(backends) == 0 {
+		return nil
+	}
+	for tries := 0; tries < len(backends); tries++ {
+		if b := backends[rand.Intn(len(backends))]; usable(b, exclude) {
+			return b
+		}
+	}
+	// most backends are unavailable, start from a random index so the
+	// remaining ones share the load
+	start := rand.Intn(len(backends))
+	for i := range backends {
+		if b := backends[(start+i)%len(backends)]; usable(b, exclude) {
+			return b
+		}
+	}
+	return nil
+}
+
+// less reports whether a has fewer active connections than b relative to
+// their effective weights
+func less(a, b *Backend) bool {
+	return float64(atomic.LoadInt64(&a.ActiveConnections)+1)/a.effectiveWeight() <
+		float64(atomic.LoadInt64(&b.ActiveConnections)+1)/b.effectiveWeight()
+}
+
+func (p *PowerOfTwoChoices) NextBackend(c net.Conn) (*Backend, error) {
+	return p.NextBackendExcluding(c, nil)
+}
+
+func (p *PowerOfTwoChoices) NextBackendExcluding(c net.Conn, exclude map[*Backend]bool) (*Backend, error) {
+	a, b := randomAvailable(p.Backends, exclude), randomAvailable(p.Backends, exclude)
+	if a == nil {
+		return nil, ErrNoBackend
+	}
+	if less(b, a) {
+		return b, nil
+	}
+	return a, nil
+}
+
+func (p *PowerOfTwoChoices) Name() string {
+	return "PowerOfTwoChoices"
+}
+
+func (p *PowerOfTwoChoices) Stats() string {
+	var out bytes.Buffer
+	out.WriteString("\n")
+	for _, b := range p.Backends {
+		fmt.Fprintf(&out, "%v active: %v weight: %.2f\n", b.Addr, atomic.LoadInt64(&b.ActiveConnections), b.effectiveWeight())
+	}
+	return out.String()
+}
+
+func (p *PowerOfTwoChoices) HandleStarted(c net.Conn) {
+	// do nothing
+}
+
+func (p *PowerOfTwoChoices) HandleDone(c net.Conn) {
+	// do nothing
+}
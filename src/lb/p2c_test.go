@@ -0,0 +1,70 @@
+package lb
+
+import (
+	"testing"
+	"time"
+)
+
+// picks counts the backends picked by n calls of NextBackend
+func picks(t *testing.T, balancer Balancer, n int) map[*Backend]int {
+	counts := make(map[*Backend]int)
+	for i := 0; i < n; i++ {
+		b, err := balancer.NextBackend(connFrom("10.1.0.1:1000"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[b]++
+	}
+	return counts
+}
+
+func TestPowerOfTwoChoices(t *testing.T) {
+	backends := testBackends(2)
+	backends[0].ActiveConnections = 10
+	counts := picks(t, &PowerOfTwoChoices{Backends: backends}, 1000)
+	// the idle backend wins unless both choices are the busy one
+	if counts[backends[1]] < 650 {
+		t.Errorf("idle backend picked %d times out of 1000", counts[backends[1]])
+	}
+
+	backends[1].setUp(false)
+	counts = picks(t, &PowerOfTwoChoices{Backends: backends}, 100)
+	if counts[backends[0]] != 100 {
+		t.Errorf("unavailable backend picked %d times", counts[backends[1]])
+	}
+}
+
+func TestPeakEWMA(t *testing.T) {
+	backends := testBackends(2)
+	p := NewPeakEWMA(backends)
+	p.ObserveLatency(backends[0], 10*time.Millisecond)
+	p.ObserveLatency(backends[1], time.Millisecond)
+	counts := picks(t, p, 1000)
+	if counts[backends[1]] < 650 {
+		t.Errorf("fast backend picked %d times out of 1000", counts[backends[1]])
+	}
+
+	// a new backend starts at the penalty rather than free
+	added := append(backends, &Backend{Addr: "10.0.0.3:80", Weight: 1})
+	p.SetBackends(added)
+	counts = picks(t, p, 900)
+	if counts[added[2]] > 250 {
+		t.Errorf("backend without samples picked %d times out of 900", counts[added[2]])
+	}
+	if got := time.Duration(p.state.Load().(*peakEWMAState).latency[backends[1]].get()); got > 2*time.Millisecond {
+		t.Errorf("latency of a kept backend reset to %v", got)
+	}
+}
+
+func TestPeakEWMAPeak(t *testing.T) {
+	e := &ewma{stamp: time.Now()}
+	e.observe(time.Millisecond, time.Second)
+	e.observe(100*time.Millisecond, time.Second)
+	if got := time.Duration(e.get()); got != 100*time.Millisecond {
+		t.Errorf("a slower sample gave %v, want it to replace the average", got)
+	}
+	e.observe(time.Millisecond, time.Second)
+	if got := time.Duration(e.get()); got < 50*time.Millisecond {
+		t.Errorf("a faster sample right after the peak gave %v, want it to decay slowly", got)
+	}
+}
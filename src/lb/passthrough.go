@@ -0,0 +1,64 @@
+package lb
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"time"
+)
+
+// serverNamer is implemented by connections that know the TLS server name
+// the client asked for without terminating TLS
+type serverNamer interface {
+	ServerName() string
+}
+
+// helloConn is a client connection whose ClientHello has been read. Reads
+// replay the ClientHello before the rest of the connection so that it reaches
+// the backend untouched.
+type helloConn struct {
+	net.Conn
+	r          io.Reader
+	serverName string
+	protos     []string
+}
+
+func (c *helloConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *helloConn) ServerName() string {
+	return c.serverName
+}
+
+// readOnlyConn lets crypto/tls parse a ClientHello without answering it
+type readOnlyConn struct {
+	r io.Reader
+}
+
+func (c readOnlyConn) Read(b []byte) (int, error)         { return c.r.Read(b) }
+func (c readOnlyConn) Write(b []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c readOnlyConn) Close() error                       { return nil }
+func (c readOnlyConn) LocalAddr() net.Addr                { return nil }
+func (c readOnlyConn) RemoteAddr() net.Addr               { return nil }
+func (c readOnlyConn) SetDeadline(t time.Time) error      { return nil }
+func (c readOnlyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c readOnlyConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// peekClientHello reads the ClientHello from conn and returns a connection
+// that replays it. The server name and protocols are empty when the client
+// does not speak TLS.
+func peekClientHello(conn net.Conn) *helloConn {
+	peeked := &bytes.Buffer{}
+	c := &helloConn{Conn: conn}
+	tls.Server(readOnlyConn{io.TeeReader(conn, peeked)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			c.serverName = hello.ServerName
+			c.protos = hello.SupportedProtos
+			return nil, io.EOF // stop the handshake
+		},
+	}).Handshake()
+	c.r = io.MultiReader(peeked, conn)
+	return c
+}
@@ -0,0 +1,104 @@
+package lb
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a self-signed certificate for names
+func testCertificate(t *testing.T, names ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// writeKeyPair writes cert to PEM files in dir and returns their paths
+func writeKeyPair(t *testing.T, dir string, cert tls.Certificate) (string, string) {
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestPeekClientHello(t *testing.T) {
+	tests := []struct {
+		serverName string
+		protos     []string
+	}{
+		{"a.example.com", []string{"h2", "http/1.1"}},
+		{"b.example.com", nil},
+		{"", nil},
+	}
+	cert := testCertificate(t, "a.example.com", "b.example.com")
+	for _, test := range tests {
+		client, server := net.Pipe()
+		go func() {
+			c := tls.Client(client, &tls.Config{ServerName: test.serverName, NextProtos: test.protos, InsecureSkipVerify: true})
+			c.Write([]byte("hello"))
+			c.Close()
+		}()
+
+		hello := peekClientHello(server)
+		if hello.serverName != test.serverName || len(hello.protos) != len(test.protos) {
+			t.Errorf("got %q %v, want %q %v", hello.serverName, hello.protos, test.serverName, test.protos)
+		}
+		// the ClientHello is replayed, so a TLS server can take over the
+		// connection as if it had never been read
+		tc := tls.Server(hello, &tls.Config{Certificates: []tls.Certificate{cert}})
+		data, err := ioutil.ReadAll(tc)
+		if err != nil || string(data) != "hello" {
+			t.Errorf("%q: read %q, %v after the peek", test.serverName, data, err)
+		}
+		server.Close()
+	}
+}
+
+func TestPeekNotTLS(t *testing.T) {
+	client, server := net.Pipe()
+	go func() {
+		client.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+		client.Close()
+	}()
+	hello := peekClientHello(server)
+	if hello.serverName != "" {
+		t.Errorf("got server name %q", hello.serverName)
+	}
+	data, _ := ioutil.ReadAll(hello)
+	if string(data) != "GET / HTTP/1.0\r\n\r\n" {
+		t.Errorf("replayed %q", data)
+	}
+}
@@ -0,0 +1,154 @@
+package lb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultEWMADecay   = 10 * time.Second
+	DefaultEWMAPenalty = 100 * time.Millisecond
+)
+
+type ewma struct {
+	sync.Mutex
+	value   float64 // nanoseconds
+	stamp   time.Time
+	sampled bool // false while value is the penalty
+}
+
+// observe moves the average towards d, a sample above the average or the
+// first sample replaces it so that a slow backend is penalised straight away
+func (e *ewma) observe(d time.Duration, decay time.Duration) {
+	e.Lock()
+	defer e.Unlock()
+	now := time.Now()
+	sample := float64(d)
+	if sample > e.value || !e.sampled {
+		e.value = sample
+		e.sampled = true
+	} else {
+		w := math.Exp(-float64(now.Sub(e.stamp)) / float64(decay))
+		e.value = e.value*w + sample*(1-w)
+	}
+	e.stamp = now
+}
+
+func (e *ewma) get() float64 {
+	e.Lock()
+	defer e.Unlock()
+	return e.value
+}
+
+// PeakEWMA tracks the connect latency and time to first byte of each backend
+// and picks the cheaper of two random backends, where the cost is the latency
+// average multiplied by the number of active connections. Backends without
+// samples start at DefaultEWMAPenalty so that a new backend is not sent every
+// connection until its first sample.
+type PeakEWMA struct {
+	sync.Mutex
+	Backends []*Backend
+	Decay    time.Duration
+	state    atomic.Value // *peakEWMAState
+}
+
+type peakEWMAState struct {
+	backends []*Backend
+	latency  map[*Backend]*ewma
+}
+
+func NewPeakEWMA(backends []*Backend) *PeakEWMA {
+	p := PeakEWMA{
+		Decay: DefaultEWMADecay,
+	}
+	p.SetBackends(backends)
+	return &p
+}
+
+// SetBackends replaces the backends, the latency averages of backends that
+// are kept carry over
+func (p *PeakEWMA) SetBackends(backends []*Backend) {
+	p.Lock()
+	defer p.Unlock()
+	var previous map[*Backend]*ewma
+	if state, ok := p.state.Load().(*peakEWMAState); ok {
+		previous = state.latency
+	}
+	latency := make(map[*Backend]*ewma, len(backends))
+	for _, b := range backends {
+		if e, exists := previous[b]; exists {
+			latency[b] = e
+		} else {
+			latency[b] = &ewma{value: float64(DefaultEWMAPenalty), stamp: time.Now()}
+		}
+	}
+	p.Backends = backends
+	p.state.Store(&peakEWMAState{backends: backends, latency: latency})
+}
+
+// ObserveLatency is called by the Proxy with connect and first byte latencies
+func (p *PeakEWMA) ObserveLatency(b *Backend, d time.Duration) {
+	if e, exists := p.state.Load().(*peakEWMAState).latency[b]; exists {
+		e.observe(d, p.Decay)
+	}
+}
+
+func cost(b *Backend, e *ewma) float64 {
+	active := float64(atomic.LoadInt64(&b.ActiveConnections) + 1)
+	return e.get() * active / b.effectiveWeight()
+}
+
+func (p *PeakEWMA) NextBackend(c net.Conn) (*Backend, error) {
+	return p.NextBackendExcluding(c, nil)
+}
+
+func (p *PeakEWMA) NextBackendExcluding(c net.Conn, exclude map[*Backend]bool) (*Backend, error) {
+	state := p.state.Load().(*peakEWMAState)
+	a, b := randomAvailable(state.backends, exclude), randomAvailable(state.backends, exclude)
+	if a == nil {
+		return nil, ErrNoBackend
+	}
+	if cost(b, state.latency[b]) < cost(a, state.latency[a]) {
+		return b, nil
+	}
+	return a, nil
+}
+
+func (p *PeakEWMA) Name() string {
+	return "PeakEWMA"
+}
+
+func (p *PeakEWMA) Stats() string {
+	var out bytes.Buffer
+	out.WriteString("\n")
+	state := p.state.Load().(*peakEWMAState)
+	for _, b := range state.backends {
+		e := state.latency[b]
+		fmt.Fprintf(&out, "%v active: %v latency: %v cost: %.0f\n", b.Addr,
+			atomic.LoadInt64(&b.ActiveConnections), time.Duration(e.get()), cost(b, e))
+	}
+	return out.String()
+}
+
+func (p *PeakEWMA) HandleStarted(c net.Conn) {
+	// do nothing
+}
+
+func (p *PeakEWMA) HandleDone(c net.Conn) {
+	// do nothing
+}
+
+func (p *PeakEWMA) MarshalJSON() ([]byte, error) {
+	p.Lock()
+	defer p.Unlock()
+	return json.Marshal(struct {
+		Backends []*Backend
+		Decay    time.Duration
+	}{p.Backends, p.Decay})
+}
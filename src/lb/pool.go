@@ -0,0 +1,205 @@
+package lb
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+// BackendSetter is implemented by balancers that can take a new list of
+// backends without losing their state, e.g. on a reload
+type BackendSetter interface {
+	SetBackends([]*Backend)
+}
+
+// pool is a set of backends together with the balancer, health checks and
+// outlier detection that go with them. A reload swaps the pool of a Proxy.
+type pool struct {
+	entry       *Entry
+	backends    []*Backend
+	balancer    Balancer
+	health      *healthChecker
+	outlier     *outlierDetector
+	timeout     time.Duration
+	setBackends bool       // the balancer of the previous pool is reused
+	resetHealth []*Backend // reused backends whose health check changed
+	routes      []*routePool
+	serverTLS   *serverTLS
+	cert        *keyPair // nil when the certificate of the listener is used
+}
+
+// newPool builds the pool for entry. Backends that are unchanged from previous
+// keep their health, ejection and connection state, new ones go through slow
+// start if previous was running. Health is only kept while the health check
+// stays the same. Nothing is changed until activate is called.
+func newPool(entry *Entry, backends []*Backend, previous *pool) (*pool, error) {
+	p := pool{
+		entry:    entry,
+		backends: make([]*Backend, len(backends)),
+		timeout:  time.Duration(entry.Timeout) * time.Second,
+	}
+	for i, b := range backends {
+		p.backends[i] = b
+		b.upstreamTLS = backendTLS(entry, b)
+		if previous != nil {
+			if old := previous.find(b); old != nil {
+				p.backends[i] = old
+				if !sameHealthCheck(previous.entry.HealthCheck, entry.HealthCheck) {
+					p.resetHealth = append(p.resetHealth, old)
+				}
+				continue
+			}
+			b.markRecovered()
+		}
+		config, err := b.upstreamTLS.config()
+		if err != nil {
+			return nil, err
+		}
+		b.tlsConfig = config
+	}
+
+	if previous != nil && !balancerChanged(previous.entry, entry) && len(splitTiers(p.backends)) <= 1 {
+		if _, ok := previous.balancer.(BackendSetter); ok {
+			p.balancer = previous.balancer
+			p.setBackends = true
+		}
+	}
+	// without backends connections that match no route are rejected
+	if p.balancer == nil && len(p.backends) > 0 {
+		balancer, err := newPriorityBalancer(entry, p.backends)
+		if err != nil {
+			return nil, err
+		}
+		p.balancer = balancer
+	}
+
+	serverTLS, err := entry.ClientAuth.compile()
+	if err != nil {
+		return nil, err
+	}
+	p.serverTLS = serverTLS
+
+	if entry.CertFile != "" && entry.KeyFile != "" {
+		if previous != nil && previous.cert != nil &&
+			previous.cert.certFile == entry.CertFile && previous.cert.keyFile == entry.KeyFile {
+			p.cert = previous.cert
+			p.cert.check()
+		} else if p.cert, err = loadKeyPair(entry.CertFile, entry.KeyFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if entry.HealthCheck != nil {
+		p.health = newHealthChecker(entry.HealthCheck, entry.Type, p.backends)
+	}
+	if entry.OutlierDetection != nil {
+		p.outlier = newOutlierDetector(entry.OutlierDetection, p.backends)
+	}
+
+	for _, route := range entry.Routes {
+		var previousRoute *pool
+		if previous != nil {
+			for _, r := range previous.routes {
+				if routeKey(r.serverNames, r.alpn) == routeKey(route.ServerNames, route.ALPN) {
+					previousRoute = r.pool
+				}
+			}
+		}
+		rp, err := newPool(routeEntry(entry, route), route.Backends, previousRoute)
+		if err != nil {
+			return nil, err
+		}
+		p.routes = append(p.routes, &routePool{pool: rp, serverNames: route.ServerNames, alpn: route.ALPN})
+	}
+	return &p, nil
+}
+
+// activate applies the settings of the pool to its backends and balancer
+func (p *pool) activate() {
+	for _, b := range p.backends {
+		atomic.StoreInt64(&b.slowStart, int64(time.Duration(p.entry.SlowStart)*time.Second))
+	}
+	if p.setBackends {
+		p.balancer.(BackendSetter).SetBackends(p.backends)
+	}
+	for _, r := range p.routes {
+		r.activate()
+	}
+}
+
+// startHealth starts the health checks of the pool and its routes
+func (p *pool) startHealth() {
+	if p.health != nil {
+		p.health.Start()
+	}
+	for _, r := range p.routes {
+		r.startHealth()
+	}
+}
+
+// stopHealth stops the health checks of the pool and its routes
+func (p *pool) stopHealth() {
+	if p.health != nil {
+		p.health.Stop()
+	}
+	for _, r := range p.routes {
+		r.stopHealth()
+	}
+}
+
+// markUp clears the health state the checks of the previous pool left on
+// reused backends, the new checks start over from up
+func (p *pool) markUp() {
+	for _, b := range p.resetHealth {
+		b.setUp(true)
+	}
+	for _, r := range p.routes {
+		r.markUp()
+	}
+}
+
+// find returns the backend of the pool with the same settings as b
+func (p *pool) find(b *Backend) *Backend {
+	for _, old := range p.backends {
+		if old.Addr == b.Addr && old.Weight == b.Weight && old.Priority == b.Priority && old.Backup == b.Backup &&
+			sameTLS(old.upstreamTLS, b.upstreamTLS) {
+			return old
+		}
+	}
+	return nil
+}
+
+func balancerChanged(a, b *Entry) bool {
+	return a.Backend != b.Backend || string(a.BalancerOptions) != string(b.BalancerOptions) ||
+		a.VirtualNodes != b.VirtualNodes || a.HashKey != b.HashKey || a.TableSize != b.TableSize
+}
+
+func sameHealthCheck(a, b *HealthCheck) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// listenerChanged reports whether b needs a different listener than a
+func listenerChanged(a, b *Entry) bool {
+	return a.Type != b.Type || a.ListenAddr != b.ListenAddr || a.CertFile != b.CertFile || a.KeyFile != b.KeyFile
+}
+
+// plainEntry wraps e so that it marshals without the state of its backends
+func plainEntry(e *Entry) interface{} {
+	type backend Backend
+	c := struct {
+		*Entry
+		Backends []*backend
+	}{Entry: e}
+	for _, b := range e.Backends {
+		c.Backends = append(c.Backends, (*backend)(b))
+	}
+	return c
+}
+
+// entryConfig is the configuration of e without the state of its backends,
+// two entries with the same entryConfig need no reload
+func entryConfig(e *Entry) string {
+	data, _ := json.Marshal(plainEntry(e))
+	return string(data)
+}
@@ -0,0 +1,144 @@
+package lb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PriorityBalancer splits the backends of an Entry into tiers, one Balancer
+// per tier. Primary backends come first ordered by Priority, then backups.
+// Connections go to the first tier that has an available backend, so traffic
+// fails over to the next tier when a whole tier is down or ejected and fails
+// back once it recovers.
+type PriorityBalancer struct {
+	tiers     [][]*Backend
+	balancers []Balancer
+	assigned  sync.Map // net.Conn -> Balancer
+}
+
+// newPriorityBalancer returns a plain Balancer when all backends share a tier
+func newPriorityBalancer(entry *Entry, backends []*Backend) (Balancer, error) {
+	tiers := splitTiers(backends)
+	if len(tiers) <= 1 {
+		return newBalancer(entry, backends)
+	}
+
+	pb := PriorityBalancer{tiers: tiers}
+	for _, tier := range tiers {
+		balancer, err := newBalancer(entry, tier)
+		if err != nil {
+			return nil, err
+		}
+		pb.balancers = append(pb.balancers, balancer)
+	}
+	return &pb, nil
+}
+
+func splitTiers(backends []*Backend) [][]*Backend {
+	sorted := make([]*Backend, len(backends))
+	copy(sorted, backends)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Backup != sorted[j].Backup {
+			return !sorted[i].Backup
+		}
+		return sorted[i].Priority < sorted[j].Priority
+	})
+
+	var tiers [][]*Backend
+	for i, b := range sorted {
+		if i == 0 || b.Backup != sorted[i-1].Backup || b.Priority != sorted[i-1].Priority {
+			tiers = append(tiers, nil)
+		}
+		tiers[len(tiers)-1] = append(tiers[len(tiers)-1], b)
+	}
+	return tiers
+}
+
+// ActiveTier is the index of the tier new connections go to, -1 if no
+// backend is available
+func (pb *PriorityBalancer) ActiveTier() int {
+	return pb.activeTier(nil)
+}
+
+// activeTier is the first tier with a backend that is available and not in
+// exclude
+func (pb *PriorityBalancer) activeTier(exclude map[*Backend]bool) int {
+	for i, tier := range pb.tiers {
+		for _, b := range tier {
+			if usable(b, exclude) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func (pb *PriorityBalancer) NextBackend(c net.Conn) (*Backend, error) {
+	return pb.NextBackendExcluding(c, nil)
+}
+
+// NextBackendExcluding fails over to the next tier once every backend of a
+// tier is excluded
+func (pb *PriorityBalancer) NextBackendExcluding(c net.Conn, exclude map[*Backend]bool) (*Backend, error) {
+	tier := pb.activeTier(exclude)
+	if tier == -1 {
+		return nil, ErrNoBackend
+	}
+
+	balancer := pb.balancers[tier]
+	if previous, exists := pb.assigned.Load(c); !exists || previous != balancer {
+		// a retry may land on another tier, the old one is done with c
+		if exists {
+			previous.(Balancer).HandleDone(c)
+		}
+		balancer.HandleStarted(c)
+		pb.assigned.Store(c, balancer)
+	}
+	return nextBackend(balancer, c, exclude)
+}
+
+// ObserveLatency passes latencies on to the tiers that want them
+func (pb *PriorityBalancer) ObserveLatency(b *Backend, d time.Duration) {
+	for _, balancer := range pb.balancers {
+		if observer, ok := balancer.(LatencyObserver); ok {
+			observer.ObserveLatency(b, d)
+		}
+	}
+}
+
+func (pb *PriorityBalancer) HandleStarted(c net.Conn) {
+	// the tier's balancer is started in NextBackend
+}
+
+func (pb *PriorityBalancer) HandleDone(c net.Conn) {
+	if balancer, exists := pb.assigned.Load(c); exists {
+		pb.assigned.Delete(c)
+		balancer.(Balancer).HandleDone(c)
+	}
+}
+
+func (pb *PriorityBalancer) Name() string {
+	return pb.balancers[0].Name()
+}
+
+func (pb *PriorityBalancer) Stats() string {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "\nActive tier: %v\n", pb.ActiveTier())
+	for i, balancer := range pb.balancers {
+		fmt.Fprintf(&out, "Tier %v:%v", i, balancer.Stats())
+	}
+	return out.String()
+}
+
+func (pb *PriorityBalancer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name       string
+		ActiveTier int
+		Tiers      []Balancer
+	}{pb.Name(), pb.ActiveTier(), pb.balancers})
+}
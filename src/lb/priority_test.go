@@ -0,0 +1,92 @@
+package lb
+
+import "testing"
+
+func TestSplitTiers(t *testing.T) {
+	backends := testBackends(5)
+	backends[0].Backup = true
+	backends[1].Priority = 1
+	backends[3].Priority = 1
+	tiers := splitTiers(backends)
+	want := [][]*Backend{{backends[2], backends[4]}, {backends[1], backends[3]}, {backends[0]}}
+	if len(tiers) != len(want) {
+		t.Fatalf("got %d tiers, want %d", len(tiers), len(want))
+	}
+	for i := range want {
+		if len(tiers[i]) != len(want[i]) {
+			t.Fatalf("tier %d has %d backends, want %d", i, len(tiers[i]), len(want[i]))
+		}
+		for j := range want[i] {
+			if tiers[i][j] != want[i][j] {
+				t.Errorf("tier %d backend %d is %s, want %s", i, j, tiers[i][j].Addr, want[i][j].Addr)
+			}
+		}
+	}
+}
+
+func TestPriorityFailover(t *testing.T) {
+	backends := testBackends(3)
+	backends[1].Priority = 1
+	backends[2].Backup = true
+	balancer, err := newPriorityBalancer(&Entry{Backend: "LeastConn"}, backends)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb := balancer.(*PriorityBalancer)
+	c := connFrom("10.1.0.1:1000")
+
+	tests := []struct {
+		down []int
+		tier int
+		want *Backend
+	}{
+		{nil, 0, backends[0]},
+		{[]int{0}, 1, backends[1]},
+		{[]int{0, 1}, 2, backends[2]},
+		{[]int{1}, 0, backends[0]}, // back to the first tier when it recovers
+		{[]int{0, 1, 2}, -1, nil},
+	}
+	for _, test := range tests {
+		for _, b := range backends {
+			b.setUp(true)
+		}
+		for _, i := range test.down {
+			backends[i].setUp(false)
+		}
+		if tier := pb.ActiveTier(); tier != test.tier {
+			t.Errorf("down %v: active tier %d, want %d", test.down, tier, test.tier)
+		}
+		b, err := pb.NextBackend(c)
+		if b != test.want || (test.want == nil) != (err != nil) {
+			t.Errorf("down %v: got %v %v, want %v", test.down, b, err, test.want)
+		}
+		pb.HandleDone(c)
+	}
+}
+
+// a retry that excludes every backend of a tier moves to the next one and
+// releases the connection from the first tier's balancer
+func TestPriorityExcludeMovesTier(t *testing.T) {
+	backends := testBackends(2)
+	backends[1].Backup = true
+	balancer, err := newPriorityBalancer(&Entry{Backend: "LeastConn"}, backends)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb := balancer.(*PriorityBalancer)
+	c := connFrom("10.1.0.1:1000")
+	if b, _ := pb.NextBackend(c); b != backends[0] {
+		t.Fatalf("got %v, want the primary", b)
+	}
+	if b, _ := pb.NextBackendExcluding(c, map[*Backend]bool{backends[0]: true}); b != backends[1] {
+		t.Fatalf("got %v, want the backup", b)
+	}
+	primary := pb.balancers[0].(*LeastConn)
+	if _, exists := primary.assigned.Load(c); exists {
+		t.Error("the primary tier still counts the connection")
+	}
+	pb.HandleDone(c)
+	if _, exists := pb.assigned.Load(c); exists {
+		t.Error("connection still assigned after HandleDone")
+	}
+}
@@ -4,6 +4,7 @@ package lb
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"io"
 	"log"
@@ -16,6 +17,8 @@ import (
 
 const BackoffTime = 500
 
+var ErrNoBackend = errors.New("no backend available")
+
 type Balancer interface {
 	NextBackend(net.Conn) (*Backend, error)
 	HandleStarted(net.Conn)
@@ -24,16 +27,143 @@ type Balancer interface {
 	Name() string
 }
 
+// ExcludingBalancer is implemented by balancers that can pick a backend other
+// than those that already failed for a connection, so that a retry does not
+// land on the same backend
+type ExcludingBalancer interface {
+	NextBackendExcluding(c net.Conn, exclude map[*Backend]bool) (*Backend, error)
+}
+
+// nextBackend picks a backend for c that is not in exclude. Balancers that
+// cannot exclude backends are asked again until they pick another one.
+func nextBackend(balancer Balancer, c net.Conn, exclude map[*Backend]bool) (*Backend, error) {
+	if eb, ok := balancer.(ExcludingBalancer); ok {
+		return eb.NextBackendExcluding(c, exclude)
+	}
+	for tries := 0; tries <= len(exclude); tries++ {
+		b, err := balancer.NextBackend(c)
+		if err != nil || !exclude[b] {
+			return b, err
+		}
+	}
+	return nil, ErrNoBackend
+}
+
+// usable reports whether b may be picked for a connection that already failed
+// on the backends in exclude
+func usable(b *Backend, exclude map[*Backend]bool) bool {
+	return b.Available() && !exclude[b]
+}
+
+// LatencyObserver is implemented by balancers that want the connect latency
+// and time to first byte of each backend connection
+type LatencyObserver interface {
+	ObserveLatency(*Backend, time.Duration)
+}
+
 // Addr is an ip:port string
 type Backend struct {
 	Addr              string
-	ActiveConnections int64
+	Weight            int
+	Priority          int         // lower priorities are used first
+	Backup            bool        // only used when no other backend is available
+	TLS               *BackendTLS `json:",omitempty"`
+	ActiveConnections int64       `json:"-"`
+	upstreamTLS       *BackendTLS // the settings tlsConfig was built from
+	tlsConfig         *tls.Config
+	down              int32
+	ejectedUntil      int64      // unix nanoseconds
+	outlier           sync.Mutex // guards failures and ejections, backends are shared by the pools of a reload
+	failures          int
+	ejections         int
+	slowStart         int64 // nanoseconds
+	recoveredAt       int64 // unix nanoseconds
+}
+
+func (b *Backend) MarshalJSON() ([]byte, error) {
+	type backend Backend
+	return json.Marshal(struct {
+		*backend
+		ActiveConnections int64
+		EffectiveWeight   float64
+		Up                bool
+		Ejected           bool
+	}{
+		backend:           (*backend)(b),
+		ActiveConnections: atomic.LoadInt64(&b.ActiveConnections),
+		EffectiveWeight:   b.effectiveWeight(),
+		Up:                b.Up(),
+		Ejected:           b.Ejected(),
+	})
+}
+
+// weight is the configured Weight, backends without one count as 1
+func (b *Backend) weight() int {
+	if b.Weight <= 0 {
+		return 1
+	}
+	return b.Weight
 }
 
-// TODO This should be able to dial TLS also
+// Up reports the result of the active health checks
+func (b *Backend) Up() bool {
+	return atomic.LoadInt32(&b.down) == 0
+}
+
+func (b *Backend) setUp(up bool) {
+	if up {
+		atomic.StoreInt32(&b.down, 0)
+	} else {
+		atomic.StoreInt32(&b.down, 1)
+	}
+}
+
+// Ejected reports whether outlier detection has temporarily removed the backend
+func (b *Backend) Ejected() bool {
+	return time.Now().UnixNano() < atomic.LoadInt64(&b.ejectedUntil)
+}
+
+// Available reports whether a Balancer may route new connections to the backend
+func (b *Backend) Available() bool {
+	return b.Up() && !b.Ejected()
+}
+
+// Dial connects to the backend, over TLS when the backend has TLS enabled
 func (b *Backend) Dial(connType string, timeout time.Duration) (net.Conn, error) {
+	return b.DialWithHeader(connType, timeout, nil)
+}
+
+// DialWithHeader dials the backend and sends header, e.g. a PROXY protocol
+// header, ahead of the TLS handshake if any
+func (b *Backend) DialWithHeader(connType string, timeout time.Duration, header []byte) (net.Conn, error) {
 	conn, err := net.DialTimeout(connType, b.Addr, timeout)
-	return conn, err
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if len(header) > 0 {
+		if _, err := conn.Write(header); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if b.tlsConfig != nil && connType == "tcp" {
+		config := b.tlsConfig
+		if config.ServerName == "" {
+			// as tls.Dial does, verify the host of the address
+			host, _, _ := net.SplitHostPort(b.Addr)
+			config = config.Clone()
+			config.ServerName = host
+		}
+		tc := tls.Client(conn, config)
+		if err := tc.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
 }
 
 func (b *Backend) DialUDP() (*net.UDPConn, error) {
@@ -45,6 +175,17 @@ func (b *Backend) DialUDP() (*net.UDPConn, error) {
 	return backend, err
 }
 
+// udpPacket is handed to the Balancer for each datagram so that every packet is
+// a distinct connection whose RemoteAddr is the client
+type udpPacket struct {
+	*net.UDPConn
+	addr net.Addr
+}
+
+func (u *udpPacket) RemoteAddr() net.Addr {
+	return u.addr
+}
+
 func (b *Backend) inc() {
 	atomic.AddInt64(&b.ActiveConnections, 1)
 }
@@ -56,41 +197,44 @@ func (b *Backend) dec() {
 // Proxy connections from Listen to Backend.
 type Proxy struct {
 	sync.Mutex
-	listener net.Listener
-	udpConn  *net.UDPConn
-	Listen   string
-	Type     string
-	Backends []*Backend
-	Balancer Balancer
-	Stopped  bool
-	Timeout  int
-	CertFile string
-	KeyFile  string
-	useTls   bool
-}
-
-func NewProxy(entry *Entry) *Proxy {
+	listener         net.Listener
+	tcpSocket        net.Listener // listener without TLS, handed over on upgrades
+	udpConn          *net.UDPConn
+	Listen           string
+	Type             string
+	Backends         []*Backend
+	Balancer         Balancer
+	Stopped          bool
+	Draining         bool
+	OpenConnections  int64
+	Timeout          int
+	DrainTimeout     int
+	CertFile         string
+	KeyFile          string
+	Certificate      *keyPair `json:",omitempty"`
+	HealthCheck      *HealthCheck
+	OutlierDetection *OutlierDetection
+	Routes           []*RouteStatus
+	pool             *pool
+	conns            map[net.Conn]bool
+	listening        chan bool
+	done             chan bool
+	running          bool
+	closed           bool
+	started          bool // set by the Manager
+	useTls           bool
+}
+
+func NewProxy(entry *Entry) (*Proxy, error) {
 	proxy := Proxy{
-		Listen:   entry.ListenAddr,
-		Backends: entry.Backends,
-		Type:     entry.Type,
-		Timeout:  entry.Timeout,
+		Listen:    entry.ListenAddr,
+		Type:      entry.Type,
+		conns:     make(map[net.Conn]bool),
+		listening: make(chan bool),
+		done:      make(chan bool),
 	}
-
-	// UDP only supports RoundRobin
-	if entry.Type == "udp" {
-		proxy.Balancer = &RoundRobin{Backends: entry.Backends}
-	} else {
-		switch entry.Backend {
-		case "RoundRobin":
-			proxy.Balancer = &RoundRobin{Backends: entry.Backends}
-		case "Hash":
-			proxy.Balancer = &Hash{Backends: entry.Backends}
-		case "LeastConn":
-			proxy.Balancer = NewLeastConn(proxy.Backends)
-		default:
-			log.Fatal("error: upsupported backend '%s'")
-		}
+	if err := proxy.Update(entry); err != nil {
+		return nil, err
 	}
 
 	if entry.CertFile != "" && entry.KeyFile != "" {
@@ -98,46 +242,256 @@ func NewProxy(entry *Entry) *Proxy {
 		proxy.CertFile = entry.CertFile
 		proxy.KeyFile = entry.KeyFile
 	}
-	return &proxy
+	return &proxy, nil
+}
+
+// Update swaps the backends, balancer and settings of the proxy for those of
+// entry without touching its listener or open connections
+func (p *Proxy) Update(entry *Entry) error {
+	pool, err := p.prepare(entry)
+	if err != nil {
+		return err
+	}
+	p.swap(pool)
+	return nil
+}
+
+// prepare builds the pool for entry without affecting the running proxy
+func (p *Proxy) prepare(entry *Entry) (*pool, error) {
+	return newPool(entry, entry.Backends, p.currentPool())
+}
+
+// swap makes pool the current pool of the proxy
+func (p *Proxy) swap(pool *pool) {
+	entry := pool.entry
+	pool.activate()
+
+	p.Lock()
+	previous := p.pool
+	p.pool = pool
+	p.Backends = pool.backends
+	p.Balancer = pool.balancer
+	p.Timeout = entry.Timeout
+	p.DrainTimeout = entry.DrainTimeout
+	p.HealthCheck = entry.HealthCheck
+	p.OutlierDetection = entry.OutlierDetection
+	p.Routes = pool.routeStatus()
+	p.Certificate = pool.cert
+	running := p.running
+	p.Unlock()
+
+	if previous != nil {
+		previous.stopHealth()
+	}
+	pool.markUp()
+	if running {
+		pool.startHealth()
+	}
+}
+
+func (p *Proxy) currentPool() *pool {
+	p.Lock()
+	defer p.Unlock()
+	return p.pool
+}
+
+func (p *Proxy) entry() *Entry {
+	return p.currentPool().entry
+}
+
+// MarshalJSON takes a snapshot of the proxy under its lock, a reload swaps
+// its backends and settings at any time
+func (p *Proxy) MarshalJSON() ([]byte, error) {
+	p.Lock()
+	snapshot := struct {
+		Listen           string
+		Type             string
+		Backends         []*Backend
+		Balancer         Balancer
+		Stopped          bool
+		Draining         bool
+		OpenConnections  int64
+		Timeout          int
+		DrainTimeout     int
+		CertFile         string
+		KeyFile          string
+		Certificate      *keyPair `json:",omitempty"`
+		HealthCheck      *HealthCheck
+		OutlierDetection *OutlierDetection
+		Routes           []*RouteStatus
+	}{
+		Listen:           p.Listen,
+		Type:             p.Type,
+		Backends:         p.Backends,
+		Balancer:         p.Balancer,
+		Stopped:          p.Stopped,
+		Draining:         p.Draining,
+		OpenConnections:  atomic.LoadInt64(&p.OpenConnections),
+		Timeout:          p.Timeout,
+		DrainTimeout:     p.DrainTimeout,
+		CertFile:         p.CertFile,
+		KeyFile:          p.KeyFile,
+		Certificate:      p.Certificate,
+		HealthCheck:      p.HealthCheck,
+		OutlierDetection: p.OutlierDetection,
+		Routes:           p.Routes,
+	}
+	p.Unlock()
+	return json.Marshal(snapshot)
+}
+
+// setDraining marks the proxy as no longer accepting connections
+func (p *Proxy) setDraining() {
+	p.Lock()
+	p.Draining = true
+	p.Unlock()
+}
+
+func (p *Proxy) isDraining() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.Draining
+}
+
+func (p *Proxy) setStopped() {
+	p.Lock()
+	p.Stopped = true
+	p.Unlock()
 }
 
 // TODO improve this output
 func (p *Proxy) Stats() {
 	logGreen(p.Listen)
-	log.Printf("%v [%v]", p.Balancer.Name(), p.Type)
-	log.Println(p.Balancer.Stats())
+	balancer := p.currentPool().balancer
+	if balancer == nil {
+		return
+	}
+	log.Printf("%v [%v]", balancer.Name(), p.Type)
+	log.Println(balancer.Stats())
 }
 
-func (p *Proxy) listenUDP() error {
-	listen_addr, err := net.ResolveUDPAddr("udp", p.Listen)
-	if err != nil {
-		log.Fatal(err)
+// bind opens the listening socket of the proxy unless it is already open, so
+// that an address in use is found before the proxy runs. It fails with
+// net.ErrClosed once the proxy is closed.
+func (p *Proxy) bind() error {
+	p.Lock()
+	defer p.Unlock()
+	if p.closed {
+		return net.ErrClosed
+	}
+	if p.tcpSocket != nil || p.udpConn != nil {
+		return nil
+	}
+	var err error
+	if p.Type == "udp" {
+		p.udpConn, err = listenPacket(p.Listen)
+	} else if p.Type == "tcp" {
+		p.tcpSocket, err = listenSocket(p.Type, p.Listen)
+		// Close stops a proxy that is closed before it accepts
+		p.listener = p.tcpSocket
+	}
+	return err
+}
+
+// unbind closes the socket of a proxy that was bound but never run
+func (p *Proxy) unbind() {
+	p.Lock()
+	defer p.Unlock()
+	if p.tcpSocket != nil {
+		p.tcpSocket.Close()
+		p.tcpSocket, p.listener = nil, nil
+	} else if p.udpConn != nil {
+		p.udpConn.Close()
+		p.udpConn = nil
+	}
+}
+
+// copySocket binds p to a copy of the socket of old, which keeps serving on its
+// own copy until it is closed. It reports false when the socket cannot be
+// copied, e.g. on windows or when old is not bound.
+func (p *Proxy) copySocket(old *Proxy) bool {
+	socket, ok := old.socket().(filer)
+	if !ok {
+		return false
 	}
-	conn, err := net.ListenUDP("udp", listen_addr)
+	f, err := socket.File()
 	if err != nil {
-		log.Fatal(err)
+		return false
+	}
+	defer f.Close()
+
+	p.Lock()
+	defer p.Unlock()
+	if p.Type == "udp" {
+		conn, err := net.FilePacketConn(f)
+		if err != nil {
+			return false
+		}
+		udpConn, ok := conn.(*net.UDPConn)
+		if !ok {
+			conn.Close()
+			return false
+		}
+		p.udpConn = udpConn
+	} else {
+		listener, err := net.FileListener(f)
+		if err != nil {
+			return false
+		}
+		p.tcpSocket, p.listener = listener, listener
 	}
-	p.udpConn = conn
+	return true
+}
+
+func (p *Proxy) listenUDP() error {
+	if err := p.bind(); err == net.ErrClosed {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	p.Lock()
+	conn := p.udpConn
+	p.Unlock()
+	close(p.listening)
 
 	log.Printf("[udp] listening on %v\n", p.Listen)
 
+	wg := &sync.WaitGroup{}
 	for {
 		buffer := make([]byte, 4096)
 		n, addr, err := conn.ReadFrom(buffer)
 		if err != nil {
-			log.Println(err)
+			// NOTE Close() sets a read deadline so replies can still be sent
+			if !p.isDraining() {
+				log.Println(err)
+			}
 			break
 		}
 
-		backend, err := p.Balancer.NextBackend(conn)
+		pool := p.currentPool()
+		packet := &udpPacket{UDPConn: conn, addr: addr}
+		pool.balancer.HandleStarted(packet)
+		backend, err := pool.balancer.NextBackend(packet)
+		if err != nil {
+			log.Printf("error getting backend: %s", err)
+			pool.balancer.HandleDone(packet)
+			continue
+		}
 
+		wg.Add(1)
 		go func(bytes_read int, client_addr net.Addr) {
+			defer wg.Done()
+			defer pool.balancer.HandleDone(packet)
 			backend_conn, err := backend.DialUDP()
 			if err != nil {
 				log.Printf("error: %v\n", err)
+				pool.outlier.failure(backend)
 				return
 			}
 			defer backend_conn.Close()
+			backend.inc()
+			defer backend.dec()
+			backend_conn.SetReadDeadline(time.Now().Add(pool.timeout))
 			_, err = backend_conn.Write(buffer[:bytes_read]) // TODO validate the number of bytes written
 			if err != nil {
 				log.Printf("error: %v\n", err)
@@ -156,25 +510,40 @@ func (p *Proxy) listenUDP() error {
 		}(n, addr)
 	}
 
+	p.drain(wg)
+	conn.Close()
+	log.Printf("proxy %s stopped", p.Listen)
+	p.setStopped()
 	return nil
 }
 
 func (p *Proxy) listenTCP() error {
-	var err error
+	if err := p.bind(); err == net.ErrClosed {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	p.Lock()
+	socket := p.tcpSocket
+	p.Unlock()
 
+	listener := socket
 	if p.useTls {
-		cert, err := tls.LoadX509KeyPair(p.CertFile, p.KeyFile)
-		if err != nil {
-			log.Fatalf("server: loadkeys: %s", err)
-		}
-		config := tls.Config{Certificates: []tls.Certificate{cert}}
-		p.listener, err = tls.Listen(p.Type, p.Listen, &config)
-		if err != nil {
-			log.Fatalf("server: listen: %s", err)
-		}
-	} else if p.listener, err = net.Listen(p.Type, p.Listen); err != nil {
-		return err
+		// the certificates are loaded with the pool and reloaded when their
+		// files change
+		config := tls.Config{GetCertificate: p.getCertificate}
+		config.GetConfigForClient = p.getConfigForClient(&config)
+		listener = tls.NewListener(socket, &config)
+		go p.watchCerts()
+	}
+	p.Lock()
+	if p.closed {
+		// closed while the listener was set up
+		listener.Close()
 	}
+	p.listener = listener
+	p.Unlock()
+	close(p.listening)
 
 	tlsMessage := ""
 	if p.useTls {
@@ -204,14 +573,25 @@ func (p *Proxy) listenTCP() error {
 			}
 		}
 	}
-	wg.Wait()
+	p.drain(wg)
 	log.Printf("proxy %s stopped", p.Listen)
 	log.Println(errorMessage)
-	p.Stopped = true
+	p.setStopped()
 	return nil
 }
 
 func (p *Proxy) Run() error {
+	p.Lock()
+	if p.closed {
+		// Close has released the socket and finished the proxy
+		p.Unlock()
+		return nil
+	}
+	p.running = true
+	pool := p.pool
+	p.Unlock()
+	defer close(p.done)
+	pool.startHealth()
 	if p.Type == "udp" {
 		return p.listenUDP()
 	} else if p.Type == "tcp" {
@@ -220,47 +600,136 @@ func (p *Proxy) Run() error {
 	return errors.New("unknown type: " + p.Type)
 }
 
-func (p *Proxy) Close() error {
-	if p.listener != nil {
-		return p.listener.Close()
+// socket returns the listening socket of the proxy, nil if not listening
+func (p *Proxy) socket() interface{} {
+	p.Lock()
+	defer p.Unlock()
+	if p.tcpSocket != nil {
+		return p.tcpSocket
 	} else if p.udpConn != nil {
-		return p.udpConn.Close()
+		return p.udpConn
 	}
 	return nil
 }
 
-func (p *Proxy) handleTCP(conn net.Conn) {
-	defer conn.Close()
-	p.Balancer.HandleStarted(conn)
+// WaitListening blocks until the proxy is listening or has stopped
+func (p *Proxy) WaitListening() {
+	select {
+	case <-p.listening:
+	case <-p.done:
+	}
+}
 
-	blacklist := make(map[string]int)
+func (p *Proxy) Close() error {
+	p.currentPool().stopHealth()
+	p.Lock()
+	listener, udpConn := p.listener, p.udpConn
+	closed, running := p.closed, p.running
+	p.closed = true
+	p.Unlock()
+	if !running {
+		// a proxy that never ran only has a bound socket to release
+		p.unbind()
+		if !closed {
+			close(p.done)
+		}
+		return nil
+	}
+	if listener != nil {
+		return listener.Close()
+	} else if udpConn != nil {
+		// stop reading but keep the socket open to reply while draining
+		p.setDraining()
+		return udpConn.SetReadDeadline(time.Now())
+	}
+	return nil
+}
 
-	for attempts := 0; attempts < len(p.Backends); attempts++ {
-		backend, err := p.Balancer.NextBackend(conn)
+func (p *Proxy) handleTCP(conn net.Conn) {
+	defer conn.Close()
+	p.trackConn(conn)
+	defer p.untrackConn(conn)
+	// a reload may swap the pool, this connection keeps the one it started with
+	pool := p.currentPool()
+	info := &connInfo{status: "failed"}
+	if pool.entry.AccessLog {
+		start := time.Now()
+		defer func() {
+			log.Printf("access %v -> %s sni=%q client=%q backend=%s %s %v", conn.RemoteAddr(), p.Listen,
+				info.serverName, info.identity, info.backend, info.status, time.Since(start).Round(time.Millisecond))
+		}()
+	}
+	if pool.entry.SNIPassthrough {
+		conn.SetReadDeadline(time.Now().Add(pool.timeout))
+		hello := peekClientHello(conn)
+		conn.SetReadDeadline(time.Time{})
+		info.serverName = hello.serverName
+		pool = pool.forServerName(info.serverName, hello.protos)
+		conn = hello
+	} else if tc, ok := conn.(*tls.Conn); ok {
+		tc.SetDeadline(time.Now().Add(pool.timeout))
+		if err := tc.Handshake(); err != nil {
+			log.Println("tls handshake failed:", err)
+			info.serverName = tc.ConnectionState().ServerName
+			info.status = "handshake failed"
+			return
+		}
+		tc.SetDeadline(time.Time{})
+		state := tc.ConnectionState()
+		info.serverName = state.ServerName
+		info.tls = &state
+		info.identity = clientIdentity(state)
+		pool = pool.forServerName(info.serverName, nil)
+	}
+	if pool.balancer == nil {
+		log.Printf("rejecting %v: no route for server name '%s'", conn.RemoteAddr(), info.serverName)
+		info.status = "rejected"
+		return
+	}
+	var header []byte
+	if pool.entry.ProxyProtocol {
+		header = proxyHeader(conn.RemoteAddr(), conn.LocalAddr(), info)
+	}
+	pool.balancer.HandleStarted(conn)
+	defer pool.balancer.HandleDone(conn)
+
+	// failed backends are skipped for the retries of this connection, and
+	// ejected by the outlierDetector for every other connection
+	failed := make(map[*Backend]bool)
+	for attempts := 0; attempts < len(pool.backends); attempts++ {
+		backend, err := nextBackend(pool.balancer, conn, failed)
 		if err != nil {
 			log.Printf("error getting backend: %s", err)
 			return
 		}
-		if _, exists := blacklist[backend.Addr]; exists {
-			blacklist[backend.Addr]++
-			if blacklist[backend.Addr] > len(p.Backends) {
-				log.Println("*** breaking due to blacklist ***") // TODO review
-				break
-			}
-			attempts-- // attempt to hit all backends
-			continue
-		}
-		backendConn, err := backend.Dial(p.Type, time.Duration(p.Timeout)*time.Second)
+		start := time.Now()
+		info.backend = backend.Addr
+		backendConn, err := backend.DialWithHeader(p.Type, pool.timeout, header)
 		if err != nil {
 			log.Println(err)
-			blacklist[backend.Addr] = 0
+			failed[backend] = true
+			pool.outlier.failure(backend)
 		} else {
+			if observer, ok := pool.balancer.(LatencyObserver); ok {
+				observer.ObserveLatency(backend, time.Since(start))
+				backendConn = &latencyConn{Conn: backendConn, start: time.Now(), observe: func(d time.Duration) {
+					observer.ObserveLatency(backend, d)
+				}}
+			}
 			backend.inc()
 			defer backendConn.Close()
-			defer p.Balancer.HandleDone(conn)
 			defer backend.dec()
-			if cError, bError := p.Pipe(conn, backendConn); cError != nil || bError != nil {
+			info.status = "ok"
+			cError, bError := p.Pipe(conn, backendConn)
+			if cError != nil || bError != nil {
 				log.Printf("pipe failed:\n%v\n%v\n", cError, bError)
+				info.status = "pipe failed"
+			}
+			// only a clean pipe resets the failures of the backend
+			if bError != nil {
+				pool.outlier.failure(backend)
+			} else if cError == nil {
+				pool.outlier.success(backend)
 			}
 			return // exit the attempt loop
 		}
@@ -268,15 +737,84 @@ func (p *Proxy) handleTCP(conn net.Conn) {
 	logRed("failed to reach a running backend")
 }
 
+// latencyConn reports the time to first byte of a backend connection, that is
+// from the first write (or the start of the connection when the backend
+// speaks first) to the first read
+type latencyConn struct {
+	net.Conn
+	mu      sync.Mutex
+	start   time.Time
+	wrote   int32
+	read    int32
+	observe func(time.Duration)
+}
+
+func (l *latencyConn) Write(b []byte) (int, error) {
+	if atomic.CompareAndSwapInt32(&l.wrote, 0, 1) {
+		l.mu.Lock()
+		l.start = time.Now()
+		l.mu.Unlock()
+	}
+	return l.Conn.Write(b)
+}
+
+func (l *latencyConn) Read(b []byte) (int, error) {
+	n, err := l.Conn.Read(b)
+	if n > 0 && atomic.CompareAndSwapInt32(&l.read, 0, 1) {
+		l.mu.Lock()
+		d := time.Since(l.start)
+		l.mu.Unlock()
+		l.observe(d)
+	}
+	return n, err
+}
+
+// faultConn records the first error of its reads and writes that was not
+// caused by closing it
+type faultConn struct {
+	net.Conn
+	mu  sync.Mutex
+	err error
+}
+
+func (c *faultConn) record(err error) {
+	if err == nil || err == io.EOF || errors.Is(err, net.ErrClosed) {
+		return
+	}
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.mu.Unlock()
+}
+
+func (c *faultConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.record(err)
+	return n, err
+}
+
+func (c *faultConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.record(err)
+	return n, err
+}
+
+func (c *faultConn) fault() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Pipe copies between client and backend until either side closes. It
+// returns the errors of each side, an error caused by the other side closing
+// the connection is not counted.
 func (p *Proxy) Pipe(client, backend net.Conn) (error, error) {
 	defer client.Close()
 	defer backend.Close()
 
-	var clientCopyError error
-	var backendCopyError error
-	var clientCopied int64
-	var backendCopied int64
-	clientOK := false
+	c := &faultConn{Conn: client}
+	b := &faultConn{Conn: backend}
 
 	var wg sync.WaitGroup
 	wg.Add(2)
@@ -285,22 +823,16 @@ func (p *Proxy) Pipe(client, backend net.Conn) (error, error) {
 	go func() {
 		defer backend.Close()
 		defer wg.Done()
-		if clientCopied, clientCopyError = io.Copy(backend, client); clientCopyError == nil {
-			// client has closed connection so we mark it as ok
-			clientOK = true
-		}
+		io.Copy(b, c)
 	}()
 
 	// copy backend data to client
 	go func() {
 		defer client.Close()
 		defer wg.Done()
-		backendCopied, backendCopyError = io.Copy(client, backend)
-		if clientOK {
-			backendCopyError = nil
-		}
+		io.Copy(c, b)
 	}()
 
 	wg.Wait()
-	return clientCopyError, backendCopyError
+	return c.fault(), b.fault()
 }
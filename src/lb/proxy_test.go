@@ -0,0 +1,292 @@
+package lb
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// resetServer accepts connections, reads a request and resets them
+func resetServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Read(make([]byte, 16))
+			c.(*net.TCPConn).SetLinger(0)
+			c.Close()
+		}
+	}()
+	return l
+}
+
+// startProxy runs a proxy for entry on a free port and returns its address
+func startProxy(t *testing.T, entry *Entry) (*Proxy, string) {
+	entry.ListenAddr = "127.0.0.1:0"
+	if entry.Type == "" {
+		entry.Type = "tcp"
+	}
+	if entry.Backend == "" {
+		entry.Backend = "RoundRobin"
+	}
+	if entry.Timeout == 0 {
+		entry.Timeout = 1
+	}
+	for _, b := range entry.Backends {
+		b.Weight = 1
+	}
+	p, err := NewProxy(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Run()
+	p.WaitListening()
+	t.Cleanup(func() { p.Close() })
+	if conn, ok := p.socket().(*net.UDPConn); ok {
+		return p, conn.LocalAddr().String()
+	}
+	return p, p.socket().(net.Listener).Addr().String()
+}
+
+func request(t *testing.T, addr string) {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("hello"))
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	io.Copy(ioutil.Discard, c)
+}
+
+func TestPipeBackendReset(t *testing.T) {
+	l := resetServer(t)
+	defer l.Close()
+	client, proxySide := net.Pipe()
+	backend, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		client.Write([]byte("hello"))
+		io.Copy(ioutil.Discard, client)
+	}()
+	cError, bError := (&Proxy{}).Pipe(proxySide, backend)
+	if bError == nil {
+		t.Fatalf("backend reset not reported, client error %v", cError)
+	}
+}
+
+func TestPipeClientClose(t *testing.T) {
+	// the backend echoes until the client goes away
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			io.Copy(c, c)
+			c.Close()
+		}
+	}()
+	client, proxySide := net.Pipe()
+	backend, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		client.Write([]byte("hello"))
+		client.Read(make([]byte, 5))
+		client.Close()
+	}()
+	if _, bError := (&Proxy{}).Pipe(proxySide, backend); bError != nil {
+		t.Fatalf("closing the client charged to the backend: %v", bError)
+	}
+}
+
+func TestOutlierEjectsOnPipeFailures(t *testing.T) {
+	l := resetServer(t)
+	defer l.Close()
+	entry := &Entry{
+		Backends: []*Backend{{Addr: l.Addr().String()}},
+		OutlierDetection: &OutlierDetection{
+			ConsecutiveFailures: 2,
+			BaseEjectionTime:    30,
+			MaxEjectionTime:     300,
+			MaxEjectionPercent:  100,
+		},
+	}
+	p, addr := startProxy(t, entry)
+	request(t, addr)
+	if p.Backends[0].Ejected() {
+		t.Fatal("ejected after one failure")
+	}
+	request(t, addr)
+	time.Sleep(50 * time.Millisecond)
+	if !p.Backends[0].Ejected() {
+		t.Fatal("not ejected after two pipe failures")
+	}
+}
+
+// replyServer answers every connection with "ok"
+func replyServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Write([]byte("ok"))
+			c.Close()
+		}
+	}()
+	return l
+}
+
+// closedAddr returns an address nothing listens on
+func closedAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestRetrySkipsFailedBackend(t *testing.T) {
+	live := replyServer(t)
+	defer live.Close()
+	dead := closedAddr(t)
+	for _, balancer := range []string{"Hash", "ConsistentHash", "Maglev", "RoundRobin", "LeastConn"} {
+		// both orders so that the client hashes to the dead backend in one
+		for _, addrs := range [][]string{{dead, live.Addr().String()}, {live.Addr().String(), dead}} {
+			entry := &Entry{Backend: balancer, Backends: []*Backend{{Addr: addrs[0]}, {Addr: addrs[1]}}}
+			if balancer == "Maglev" {
+				entry.TableSize = 13
+			} else if balancer == "ConsistentHash" {
+				entry.VirtualNodes = 10
+			}
+			_, addr := startProxy(t, entry)
+			c, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reply, _ := ioutil.ReadAll(c)
+			c.Close()
+			if string(reply) != "ok" {
+				t.Errorf("%s %v: got %q, want the live backend", balancer, addrs, reply)
+			}
+		}
+	}
+}
+
+func TestMarshalDuringUpdate(t *testing.T) {
+	live := replyServer(t)
+	defer live.Close()
+	for _, name := range []string{"RoundRobin", "LeastConn", "Maglev"} {
+		entry := &Entry{Backend: name, Backends: []*Backend{{Addr: live.Addr().String()}}}
+		p, _ := startProxy(t, entry)
+		done := make(chan bool)
+		go func() {
+			defer close(done)
+			for i := 0; i < 100; i++ {
+				update := *entry
+				update.Timeout = i + 1
+				update.Backends = []*Backend{{Addr: live.Addr().String(), Weight: 1}}
+				if err := p.Update(&update); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+		for i := 0; i < 100; i++ {
+			if _, err := json.Marshal(p); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		<-done
+		p.Close()
+		p.Wait()
+		if _, err := json.Marshal(p); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+}
+
+// udpEchoServer answers every packet with the same bytes
+func udpEchoServer(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buffer := make([]byte, 4096)
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buffer[:n], addr)
+		}
+	}()
+	return conn
+}
+
+// every packet is balanced on its own and counted until its reply
+func TestUDPBalancers(t *testing.T) {
+	backends := []net.PacketConn{udpEchoServer(t), udpEchoServer(t)}
+	for _, b := range backends {
+		defer b.Close()
+	}
+	for _, balancer := range []string{"RoundRobin", "LeastConn", "PowerOfTwoChoices", "PeakEWMA", "Hash", "Maglev"} {
+		entry := &Entry{Type: "udp", Backend: balancer, Backends: []*Backend{
+			{Addr: backends[0].LocalAddr().String()}, {Addr: backends[1].LocalAddr().String()},
+		}}
+		if balancer == "Maglev" {
+			entry.TableSize = 13
+		}
+		p, addr := startProxy(t, entry)
+		client, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 20; i++ {
+			client.Write([]byte("ping"))
+			client.SetReadDeadline(time.Now().Add(2 * time.Second))
+			reply := make([]byte, 16)
+			if n, err := client.Read(reply); err != nil || string(reply[:n]) != "ping" {
+				t.Fatalf("%s: got %q %v", balancer, reply[:n], err)
+			}
+		}
+		client.Close()
+		for _, b := range p.Backends {
+			if !waitFor(time.Second, func() bool { return atomic.LoadInt64(&b.ActiveConnections) == 0 }) {
+				t.Errorf("%s: %s still has %d active packets", balancer, b.Addr, atomic.LoadInt64(&b.ActiveConnections))
+			}
+		}
+		if lc, ok := p.currentPool().balancer.(*LeastConn); ok {
+			count := 0
+			lc.assigned.Range(func(k, v interface{}) bool { count++; return true })
+			if count != 0 {
+				t.Errorf("LeastConn still tracks %d packets", count)
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package lb
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+)
+
+// PROXY protocol version 2, see
+// https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	proxyV2Command   = 0x21 // version 2, PROXY
+	proxyV2Unspec    = 0x00
+	proxyV2TCP4      = 0x11
+	proxyV2TCP6      = 0x21
+	pp2TypeALPN      = 0x01
+	pp2TypeAuthority = 0x02
+	pp2TypeSSL       = 0x20
+	pp2SSLVersion    = 0x21
+	pp2SSLCN         = 0x22
+	pp2SSLCipher     = 0x23
+	pp2ClientSSL     = 0x01
+	pp2ClientCert    = 0x02 // PP2_CLIENT_CERT_CONN
+)
+
+// connInfo is what is known about a client connection before it is handed to
+// a backend
+type connInfo struct {
+	serverName string
+	tls        *tls.ConnectionState // nil unless TLS was terminated
+	identity   string
+	backend    string
+	status     string // for the access log
+}
+
+func appendTLV(buf *bytes.Buffer, typ byte, value []byte) {
+	buf.WriteByte(typ)
+	binary.Write(buf, binary.BigEndian, uint16(len(value)))
+	buf.Write(value)
+}
+
+// proxyHeader builds a PROXY protocol v2 header for a connection from client
+// to local. The server name, the negotiated protocol and the TLS details with
+// the subject common name of a verified client certificate are sent as TLVs.
+func proxyHeader(client, local net.Addr, info *connInfo) []byte {
+	addrs := &bytes.Buffer{}
+	family := byte(proxyV2Unspec)
+	src, srcOK := client.(*net.TCPAddr)
+	dst, dstOK := local.(*net.TCPAddr)
+	if srcOK && dstOK {
+		if src.IP.To4() != nil && dst.IP.To4() != nil {
+			family = proxyV2TCP4
+			addrs.Write(src.IP.To4())
+			addrs.Write(dst.IP.To4())
+		} else {
+			family = proxyV2TCP6
+			addrs.Write(src.IP.To16())
+			addrs.Write(dst.IP.To16())
+		}
+		binary.Write(addrs, binary.BigEndian, uint16(src.Port))
+		binary.Write(addrs, binary.BigEndian, uint16(dst.Port))
+	}
+
+	tlvs := &bytes.Buffer{}
+	if info.serverName != "" {
+		appendTLV(tlvs, pp2TypeAuthority, []byte(info.serverName))
+	}
+	if state := info.tls; state != nil {
+		if state.NegotiatedProtocol != "" {
+			appendTLV(tlvs, pp2TypeALPN, []byte(state.NegotiatedProtocol))
+		}
+		ssl := &bytes.Buffer{}
+		client := byte(pp2ClientSSL)
+		verify := uint32(1)
+		if len(state.PeerCertificates) > 0 {
+			client |= pp2ClientCert
+			if len(state.VerifiedChains) > 0 {
+				verify = 0
+			}
+		}
+		ssl.WriteByte(client)
+		binary.Write(ssl, binary.BigEndian, verify)
+		appendTLV(ssl, pp2SSLVersion, []byte(tls.VersionName(state.Version)))
+		appendTLV(ssl, pp2SSLCipher, []byte(tls.CipherSuiteName(state.CipherSuite)))
+		if verify == 0 && state.PeerCertificates[0].Subject.CommonName != "" {
+			appendTLV(ssl, pp2SSLCN, []byte(state.PeerCertificates[0].Subject.CommonName))
+		}
+		appendTLV(tlvs, pp2TypeSSL, ssl.Bytes())
+	}
+
+	header := &bytes.Buffer{}
+	header.Write(proxyV2Signature)
+	header.WriteByte(proxyV2Command)
+	header.WriteByte(family)
+	binary.Write(header, binary.BigEndian, uint16(addrs.Len()+tlvs.Len()))
+	header.Write(addrs.Bytes())
+	header.Write(tlvs.Bytes())
+	return header.Bytes()
+}
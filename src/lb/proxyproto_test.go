@@ -0,0 +1,78 @@
+package lb
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+)
+
+func TestProxyHeader(t *testing.T) {
+	v4 := func(ip string, port int) net.Addr { return &net.TCPAddr{IP: net.ParseIP(ip), Port: port} }
+	sig := "\r\n\r\n\x00\r\nQUIT\n"
+	alice := &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}}
+	tests := []struct {
+		name          string
+		client, local net.Addr
+		info          connInfo
+		want          string
+	}{
+		{
+			"tcp4",
+			v4("10.0.0.1", 1234), v4("10.0.0.2", 443),
+			connInfo{},
+			sig + "\x21\x11\x00\x0c" + "\x0a\x00\x00\x01" + "\x0a\x00\x00\x02" + "\x04\xd2" + "\x01\xbb",
+		},
+		{
+			"tcp4 with server name",
+			v4("10.0.0.1", 1234), v4("10.0.0.2", 443),
+			connInfo{serverName: "a.test"},
+			sig + "\x21\x11\x00\x15" + "\x0a\x00\x00\x01" + "\x0a\x00\x00\x02" + "\x04\xd2" + "\x01\xbb" +
+				"\x02\x00\x06a.test",
+		},
+		{
+			"tls with a verified client certificate",
+			v4("10.0.0.1", 1234), v4("10.0.0.2", 443),
+			connInfo{tls: &tls.ConnectionState{
+				Version:          tls.VersionTLS13,
+				CipherSuite:      tls.TLS_AES_128_GCM_SHA256,
+				PeerCertificates: []*x509.Certificate{alice},
+				VerifiedChains:   [][]*x509.Certificate{{alice}},
+			}},
+			sig + "\x21\x11\x00\x3f" + "\x0a\x00\x00\x01" + "\x0a\x00\x00\x02" + "\x04\xd2" + "\x01\xbb" +
+				"\x20\x00\x30" + "\x03" + "\x00\x00\x00\x00" +
+				"\x21\x00\x07TLS 1.3" + "\x23\x00\x16TLS_AES_128_GCM_SHA256" + "\x22\x00\x05alice",
+		},
+		{
+			"tls without a client certificate",
+			v4("10.0.0.1", 1234), v4("10.0.0.2", 443),
+			connInfo{tls: &tls.ConnectionState{Version: tls.VersionTLS12, CipherSuite: tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, NegotiatedProtocol: "h2"}},
+			sig + "\x21\x11\x00\x4b" + "\x0a\x00\x00\x01" + "\x0a\x00\x00\x02" + "\x04\xd2" + "\x01\xbb" +
+				"\x01\x00\x02h2" +
+				"\x20\x00\x37" + "\x01" + "\x00\x00\x00\x01" +
+				"\x21\x00\x07TLS 1.2" + "\x23\x00\x25TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+		},
+		{
+			"tcp6",
+			v4("2001:db8::1", 1), v4("2001:db8::2", 2),
+			connInfo{},
+			sig + "\x21\x21\x00\x24" +
+				"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01" +
+				"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02" +
+				"\x00\x01\x00\x02",
+		},
+		{
+			"unspec",
+			&net.UnixAddr{Name: "a", Net: "unix"}, v4("10.0.0.2", 443),
+			connInfo{},
+			sig + "\x21\x00\x00\x00",
+		},
+	}
+	for _, test := range tests {
+		if got := proxyHeader(test.client, test.local, &test.info); !bytes.Equal(got, []byte(test.want)) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
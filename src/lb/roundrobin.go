@@ -13,13 +13,32 @@ type RoundRobin struct {
 }
 
 func (r *RoundRobin) NextBackend(c net.Conn) (*Backend, error) {
+	return r.NextBackendExcluding(c, nil)
+}
+
+func (r *RoundRobin) NextBackendExcluding(c net.Conn, exclude map[*Backend]bool) (*Backend, error) {
 	r.Lock()
 	defer r.Unlock()
-	r.backendIndex += 1
-	if r.backendIndex > len(r.Backends)-1 {
-		r.backendIndex = 0
+	var fallback *Backend
+	for i := 0; i < len(r.Backends); i++ {
+		r.backendIndex += 1
+		if r.backendIndex > len(r.Backends)-1 {
+			r.backendIndex = 0
+		}
+		if b := r.Backends[r.backendIndex]; usable(b, exclude) {
+			if b.warm() {
+				return b, nil
+			}
+			if fallback == nil {
+				fallback = b
+			}
+		}
+	}
+	// only backends in slow start are available
+	if fallback != nil {
+		return fallback, nil
 	}
-	return r.Backends[r.backendIndex], nil
+	return nil, ErrNoBackend
 }
 
 func (r *RoundRobin) Stats() string {
@@ -0,0 +1,50 @@
+package lb
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// SlowStartMinFraction is the share of its weight a backend starts with
+const SlowStartMinFraction = 0.1
+
+// markRecovered starts the slow start window of a backend that has just come
+// back up or been added
+func (b *Backend) markRecovered() {
+	atomic.StoreInt64(&b.recoveredAt, time.Now().UnixNano())
+}
+
+// slowStartFactor ramps linearly from SlowStartMinFraction to 1 over the
+// slow start window that follows a recovery or the end of an ejection
+func (b *Backend) slowStartFactor() float64 {
+	window := atomic.LoadInt64(&b.slowStart)
+	if window <= 0 {
+		return 1
+	}
+	since := atomic.LoadInt64(&b.recoveredAt)
+	if ejectedUntil := atomic.LoadInt64(&b.ejectedUntil); ejectedUntil > since {
+		since = ejectedUntil
+	}
+	elapsed := time.Now().UnixNano() - since
+	if elapsed >= window {
+		return 1
+	}
+	factor := float64(elapsed) / float64(window)
+	if factor < SlowStartMinFraction {
+		return SlowStartMinFraction
+	}
+	return factor
+}
+
+// effectiveWeight is the weight balancers should use, reduced during slow start
+func (b *Backend) effectiveWeight() float64 {
+	return float64(b.weight()) * b.slowStartFactor()
+}
+
+// warm reports whether an unweighted balancer should use the backend this
+// time, a backend in slow start is picked with the probability of its factor
+func (b *Backend) warm() bool {
+	factor := b.slowStartFactor()
+	return factor >= 1 || rand.Float64() < factor
+}
@@ -0,0 +1,66 @@
+package lb
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSlowStartFactor(t *testing.T) {
+	window := 10 * time.Second
+	tests := []struct {
+		since time.Duration // since the backend recovered
+		want  float64
+	}{
+		{0, SlowStartMinFraction},
+		{500 * time.Millisecond, SlowStartMinFraction},
+		{2 * time.Second, 0.2},
+		{5 * time.Second, 0.5},
+		{9 * time.Second, 0.9},
+		{10 * time.Second, 1},
+		{time.Minute, 1},
+	}
+	for _, test := range tests {
+		b := &Backend{Weight: 4, slowStart: int64(window), recoveredAt: time.Now().Add(-test.since).UnixNano()}
+		if got := b.slowStartFactor(); math.Abs(got-test.want) > 0.01 {
+			t.Errorf("%v after recovery: factor %.3f, want %.3f", test.since, got, test.want)
+		}
+		if got := b.effectiveWeight(); math.Abs(got-4*test.want) > 0.04 {
+			t.Errorf("%v after recovery: weight %.3f, want %.3f", test.since, got, 4*test.want)
+		}
+	}
+
+	// without a window the full weight is used straight away
+	b := &Backend{Weight: 4, recoveredAt: time.Now().UnixNano()}
+	if b.slowStartFactor() != 1 {
+		t.Error("slow start applied without SlowStart")
+	}
+}
+
+// the window also follows the end of an ejection
+func TestSlowStartAfterEjection(t *testing.T) {
+	b := &Backend{Weight: 1, slowStart: int64(10 * time.Second)}
+	b.ejectedUntil = time.Now().Add(-5 * time.Second).UnixNano()
+	if got := b.slowStartFactor(); math.Abs(got-0.5) > 0.01 {
+		t.Errorf("factor %.3f five seconds after an ejection ended, want 0.5", got)
+	}
+}
+
+func TestSlowStartLeastConn(t *testing.T) {
+	backends := testBackends(2)
+	// the second backend has just recovered and has a tenth of its weight
+	backends[1].slowStart = int64(time.Minute)
+	backends[1].markRecovered()
+	lc := NewLeastConn(backends)
+	counts := make(map[*Backend]int)
+	for i := 0; i < 110; i++ {
+		b, err := lc.NextBackend(connFrom("10.1.0.1:1000"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[b]++
+	}
+	if counts[backends[1]] < 5 || counts[backends[1]] > 15 {
+		t.Errorf("backend in slow start got %d of 110 connections, want about 10", counts[backends[1]])
+	}
+}
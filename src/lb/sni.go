@@ -0,0 +1,144 @@
+package lb
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"strings"
+)
+
+// Route sends connections whose TLS server name matches one of ServerNames,
+// exactly or by a leading "*." wildcard covering one label, to its own
+// Backends. CertFile and KeyFile are the certificate presented for those
+// names, the certificate of the Entry is used when they are empty. With
+// SNIPassthrough ALPN limits the route to clients offering one of its
+// protocols. Backend and BalancerOptions default to those of the Entry, every
+// other setting is shared with it.
+type Route struct {
+	ServerNames     []string
+	ALPN            []string
+	CertFile        string
+	KeyFile         string
+	Backend         string
+	BalancerOptions json.RawMessage
+	Backends        []*Backend
+}
+
+// RouteStatus is the state of a Route shown in /stats
+type RouteStatus struct {
+	ServerNames []string
+	ALPN        []string `json:",omitempty"`
+	Certificate *keyPair `json:",omitempty"`
+	Backends    []*Backend
+	Balancer    Balancer
+}
+
+// routePool is the pool of a Route
+type routePool struct {
+	*pool
+	serverNames []string
+	alpn        []string
+}
+
+// offers reports whether the client offers a protocol of the route, routes
+// without ALPN accept every client
+func (r *routePool) offers(protos []string) bool {
+	if len(r.alpn) == 0 {
+		return true
+	}
+	for _, want := range r.alpn {
+		for _, proto := range protos {
+			if proto == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// routeEntry returns the settings of entry with those of route applied
+func routeEntry(entry *Entry, route *Route) *Entry {
+	e := *entry
+	e.Routes = nil
+	e.CertFile, e.KeyFile = route.CertFile, route.KeyFile
+	e.Backends = route.Backends
+	if route.Backend != "" {
+		e.Backend = route.Backend
+		e.BalancerOptions = route.BalancerOptions
+	}
+	return &e
+}
+
+func routeKey(serverNames, alpn []string) string {
+	return strings.ToLower(strings.Join(serverNames, ",")) + "/" + strings.Join(alpn, ",")
+}
+
+// matchServerName reports whether name matches pattern, which is either a
+// server name or "*." followed by a domain matching one more label
+func matchServerName(pattern, name string) bool {
+	pattern, name = strings.ToLower(pattern), strings.ToLower(strings.TrimSuffix(name, "."))
+	if !strings.HasPrefix(pattern, "*.") {
+		return pattern == name
+	}
+	i := strings.IndexByte(name, '.')
+	return i > 0 && name[i:] == pattern[1:]
+}
+
+// route returns the route for a server name and the protocols offered by
+// the client, exact names are preferred over wildcards. It returns nil when
+// no route matches.
+func (p *pool) route(serverName string, protos []string) *routePool {
+	if serverName == "" {
+		return nil
+	}
+	var wildcard *routePool
+	for _, r := range p.routes {
+		if !r.offers(protos) {
+			continue
+		}
+		for _, pattern := range r.serverNames {
+			if !matchServerName(pattern, serverName) {
+				continue
+			}
+			if !strings.HasPrefix(pattern, "*.") {
+				return r
+			}
+			if wildcard == nil {
+				wildcard = r
+			}
+		}
+	}
+	return wildcard
+}
+
+// forServerName returns the pool for connections to serverName, the default
+// pool when no route matches
+func (p *pool) forServerName(serverName string, protos []string) *pool {
+	if r := p.route(serverName, protos); r != nil {
+		return r.pool
+	}
+	return p
+}
+
+func (p *pool) routeStatus() []*RouteStatus {
+	var status []*RouteStatus
+	for _, r := range p.routes {
+		status = append(status, &RouteStatus{
+			ServerNames: r.serverNames,
+			ALPN:        r.alpn,
+			Certificate: r.cert,
+			Backends:    r.backends,
+			Balancer:    r.balancer,
+		})
+	}
+	return status
+}
+
+// getCertificate picks the certificate for a TLS handshake, the certificate
+// of the matching route or that of the listener
+func (p *Proxy) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	pool := p.currentPool()
+	if r := pool.route(hello.ServerName, nil); r != nil && r.cert != nil {
+		return r.cert.get(), nil
+	}
+	return pool.cert.get(), nil
+}
@@ -0,0 +1,61 @@
+package lb
+
+import (
+	"testing"
+)
+
+func TestMatchServerName(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"a.example.com", "a.example.com", true},
+		{"a.example.com", "A.Example.COM", true},
+		{"a.example.com", "a.example.com.", true},
+		{"a.example.com", "b.example.com", false},
+		{"*.example.com", "a.example.com", true},
+		{"*.example.com", "A.EXAMPLE.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "a.b.example.com", false},
+		{"*.example.com", ".example.com", false},
+		{"*.example.com", "aexample.com", false},
+		{"example.com", "a.example.com", false},
+	}
+	for _, test := range tests {
+		if got := matchServerName(test.pattern, test.name); got != test.want {
+			t.Errorf("matchServerName(%q, %q) = %v, want %v", test.pattern, test.name, got, test.want)
+		}
+	}
+}
+
+func TestRoute(t *testing.T) {
+	wildcard := &routePool{pool: &pool{}, serverNames: []string{"*.example.com"}}
+	exact := &routePool{pool: &pool{}, serverNames: []string{"api.example.com", "www.example.com"}}
+	h2 := &routePool{pool: &pool{}, serverNames: []string{"grpc.example.com"}, alpn: []string{"h2"}}
+	other := &routePool{pool: &pool{}, serverNames: []string{"*.example.org"}}
+	p := &pool{routes: []*routePool{wildcard, h2, exact, other}}
+
+	tests := []struct {
+		serverName string
+		protos     []string
+		want       *routePool
+	}{
+		{"api.example.com", nil, exact}, // exact wins over an earlier wildcard
+		{"WWW.example.com", nil, exact},
+		{"img.example.com", nil, wildcard},
+		{"grpc.example.com", []string{"http/1.1", "h2"}, h2},
+		{"grpc.example.com", []string{"http/1.1"}, wildcard},
+		{"grpc.example.com", nil, wildcard},
+		{"a.example.org", nil, other},
+		{"example.com", nil, nil},
+		{"", nil, nil},
+	}
+	for _, test := range tests {
+		if got := p.route(test.serverName, test.protos); got != test.want {
+			t.Errorf("route(%q, %v) = %v, want %v", test.serverName, test.protos, got, test.want)
+		}
+	}
+	if p.forServerName("nothing.test", nil) != p {
+		t.Error("unmatched server name does not use the default pool")
+	}
+}
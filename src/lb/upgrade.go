@@ -0,0 +1,261 @@
+package lb
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ListenFDsEnv lists the sockets handed to a new process as type:addr
+	// pairs, in the order of the file descriptors from 3 onwards
+	ListenFDsEnv = "LB_LISTEN_FDS"
+	// ReadyFDEnv is the file descriptor the new process writes to once ready
+	ReadyFDEnv     = "LB_READY_FD"
+	UpgradeTimeout = 30
+)
+
+var (
+	inheritedOnce sync.Once
+	inheritedMux  sync.Mutex
+	inherited     = make(map[string]*os.File)
+)
+
+// inheritedFile returns the socket for key passed on by the previous process
+// during an upgrade. Each socket is only handed out once.
+func inheritedFile(key string) *os.File {
+	inheritedOnce.Do(loadInherited)
+	inheritedMux.Lock()
+	defer inheritedMux.Unlock()
+	f, exists := inherited[key]
+	delete(inherited, key)
+	if !exists {
+		return nil
+	}
+	return f
+}
+
+func loadInherited() {
+	keys := os.Getenv(ListenFDsEnv)
+	os.Unsetenv(ListenFDsEnv)
+	if keys == "" {
+		return
+	}
+	inheritedMux.Lock()
+	defer inheritedMux.Unlock()
+	for i, k := range strings.Split(keys, ",") {
+		inherited[k] = os.NewFile(uintptr(3+i), k)
+	}
+}
+
+// closeInherited closes the sockets passed on by the previous process that no
+// proxy took over, e.g. for entries removed from the config in between. The
+// socket of the HTTP server is left for HttpServer.
+func closeInherited() {
+	inheritedOnce.Do(loadInherited)
+	inheritedMux.Lock()
+	defer inheritedMux.Unlock()
+	for key, f := range inherited {
+		if key == "tcp:"+HTTPListenAddr {
+			continue
+		}
+		log.Printf("upgrade: closing unused inherited socket %s", key)
+		f.Close()
+		delete(inherited, key)
+	}
+}
+
+// listenSocket reuses an inherited listener for addr or creates a new one
+func listenSocket(network, addr string) (net.Listener, error) {
+	if f := inheritedFile(network + ":" + addr); f != nil {
+		defer f.Close()
+		log.Printf("[%s] using inherited listener for %s", network, addr)
+		return net.FileListener(f)
+	}
+	return net.Listen(network, addr)
+}
+
+func listenPacket(addr string) (*net.UDPConn, error) {
+	if f := inheritedFile("udp:" + addr); f != nil {
+		defer f.Close()
+		log.Printf("[udp] using inherited socket for %s", addr)
+		conn, err := net.FilePacketConn(f)
+		if err != nil {
+			return nil, err
+		}
+		if udpConn, ok := conn.(*net.UDPConn); ok {
+			return udpConn, nil
+		}
+		conn.Close()
+		return nil, errors.New("inherited socket is not UDP: " + addr)
+	}
+	listenAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenUDP("udp", listenAddr)
+}
+
+type filer interface {
+	File() (*os.File, error)
+}
+
+// socketFiles returns the listening sockets of the running proxies and the
+// HTTP server keyed as for inheritedFile
+func (m *Manager) socketFiles() ([]string, []*os.File, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	sockets := make(map[string]interface{})
+	for _, p := range m.proxies {
+		if socket := p.socket(); socket != nil {
+			sockets[p.Type+":"+p.Listen] = socket
+		}
+	}
+	if m.httpListener != nil {
+		sockets["tcp:"+HTTPListenAddr] = m.httpListener
+	}
+
+	var keys []string
+	var files []*os.File
+	for key, socket := range sockets {
+		s, ok := socket.(filer)
+		if !ok {
+			continue
+		}
+		f, err := s.File()
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, nil, fmt.Errorf("%s: %v", key, err)
+		}
+		keys = append(keys, key)
+		files = append(files, f)
+	}
+	return keys, files, nil
+}
+
+// Upgrade starts the current executable, which may have been replaced by a
+// new build, handing it the listening sockets. Once the new process reports
+// that its proxies are listening this one drains and exits.
+func (m *Manager) Upgrade() error {
+	m.Lock()
+	if m.upgrading {
+		m.Unlock()
+		return errors.New("upgrade already in progress")
+	}
+	m.upgrading = true
+	m.Unlock()
+	defer func() {
+		m.Lock()
+		m.upgrading = false
+		m.Unlock()
+	}()
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	keys, files, err := m.socketFiles()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, readyWriter)
+	cmd.Env = append(os.Environ(),
+		ListenFDsEnv+"="+strings.Join(keys, ","),
+		ReadyFDEnv+"="+strconv.Itoa(3+len(files)))
+	err = cmd.Start()
+	readyWriter.Close()
+	if err != nil {
+		return err
+	}
+	log.Printf("upgrade: started %v: pid = %v", executable, cmd.Process.Pid)
+
+	result := make(chan error, 1)
+	go func() {
+		b := make([]byte, 1)
+		if _, err := ready.Read(b); err != nil {
+			result <- errors.New("new process exited before it was ready")
+		} else {
+			result <- nil
+		}
+	}()
+
+	select {
+	case err = <-result:
+	case <-time.After(UpgradeTimeout * time.Second):
+		err = errors.New("timed out waiting for the new process")
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		go cmd.Wait()
+		return err
+	}
+
+	logGreen(fmt.Sprintf("upgrade: pid %v is ready, draining pid %v", cmd.Process.Pid, os.Getpid()))
+	m.handOff()
+	go func() {
+		m.stopProxies()
+		m.doneChan <- true
+	}()
+	return nil
+}
+
+// handOff stops the config watcher and the HTTP server once the new process
+// serves them, the proxies are left to drain
+func (m *Manager) handOff() {
+	m.watcher.Stop()
+	m.Lock()
+	m.handedOff = true
+	listener := m.httpListener
+	m.Unlock()
+	if listener != nil {
+		listener.Close()
+	}
+}
+
+// notifyReady tells the process that started this one, if any, that every
+// proxy is listening
+func (m *Manager) notifyReady() {
+	fd, err := strconv.Atoi(os.Getenv(ReadyFDEnv))
+	os.Unsetenv(ReadyFDEnv)
+	if err != nil {
+		return
+	}
+
+	m.Lock()
+	proxies := m.proxies
+	m.Unlock()
+	for _, p := range proxies {
+		p.WaitListening()
+	}
+
+	f := os.NewFile(uintptr(fd), "ready")
+	defer f.Close()
+	if _, err := f.Write([]byte{1}); err != nil {
+		log.Println("upgrade: unable to notify parent:", err)
+	}
+}
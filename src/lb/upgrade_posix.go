@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package lb
+
+import (
+	"os"
+	"syscall"
+)
+
+var upgradeSignal os.Signal = syscall.SIGUSR2
@@ -0,0 +1,113 @@
+//go:build !windows
+// +build !windows
+
+package lb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// upgradeChildEnv names the config of a test binary started by Upgrade, which
+// then runs a Manager instead of the tests
+const upgradeChildEnv = "LB_TEST_UPGRADE_CONFIG"
+
+func TestMain(m *testing.M) {
+	if config := os.Getenv(upgradeChildEnv); config != "" {
+		upgradeChild(config)
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// upgradeChild records its pid next to the config so that the test can stop
+// it, then serves the config until it gets SIGTERM
+func upgradeChild(config string) {
+	if err := ioutil.WriteFile(config+".pid", []byte(strconv.Itoa(os.Getpid())), 0600); err != nil {
+		os.Exit(1)
+	}
+	m := NewManager(config)
+	m.Run()
+	m.Wait()
+}
+
+func writeEntries(t *testing.T, path, backend string, listen ...string) {
+	entries := ""
+	for i, addr := range listen {
+		if i > 0 {
+			entries += ","
+		}
+		entries += fmt.Sprintf(`{"ListenAddr": "%s", "Type": "tcp", "Backend": "RoundRobin", "Timeout": 1, "Backends": [{"Addr": "%s", "Weight": 1}]}`, addr, backend)
+	}
+	if err := ioutil.WriteFile(path, []byte(`{"Entries": [`+entries+`]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpgradeHandsOverSockets(t *testing.T) {
+	live := replyServer(t)
+	defer live.Close()
+	path := filepath.Join(t.TempDir(), "config.json")
+	kept, removed := closedAddr(t), closedAddr(t)
+	writeEntries(t, path, live.Addr().String(), kept, removed)
+	m := testManager(t, path)
+	m.doneChan = make(chan bool)
+	if err := m.reload(); err != nil {
+		t.Fatal(err)
+	}
+	m.Run()
+	for _, p := range m.proxies {
+		p.WaitListening()
+	}
+
+	// the new process is handed both sockets but only takes over one
+	writeEntries(t, path, live.Addr().String(), kept)
+	t.Setenv(upgradeChildEnv, path)
+	if err := m.Upgrade(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		pid, err := ioutil.ReadFile(path + ".pid")
+		if err != nil {
+			t.Fatal(err)
+		}
+		child, _ := strconv.Atoi(string(pid))
+		process, _ := os.FindProcess(child)
+		process.Signal(syscall.SIGTERM)
+		process.Wait()
+	}()
+	select {
+	case <-m.watcher.stop:
+	default:
+		t.Error("config watcher still running after the upgrade")
+	}
+
+	// this process drains and leaves the sockets to the new one
+	done := make(chan bool)
+	go func() { done <- m.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not stopped after the upgrade")
+	}
+	c, err := net.Dial("tcp", kept)
+	if err != nil {
+		t.Fatalf("listener not taken over: %v", err)
+	}
+	reply, _ := ioutil.ReadAll(c)
+	c.Close()
+	if string(reply) != "ok" {
+		t.Fatalf("got %q from the new process", reply)
+	}
+	if c, err := net.Dial("tcp", removed); err == nil {
+		c.Close()
+		t.Error("unused inherited socket still open in the new process")
+	}
+}
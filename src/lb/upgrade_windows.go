@@ -0,0 +1,8 @@
+package lb
+
+import (
+	"os"
+)
+
+// upgrades are not supported on windows
+var upgradeSignal os.Signal
@@ -0,0 +1,348 @@
+package lb
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ValidationError is a problem with a field of the config. Entry is the index
+// of the entry in Entries and Line the line it starts on, 0 if unknown. File is
+// set for entries read from an included config.
+type ValidationError struct {
+	Entry   int
+	File    string
+	Line    int
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	where := ""
+	switch {
+	case e.File != "" && e.Line > 0:
+		where = fmt.Sprintf(" (%s line %d)", e.File, e.Line)
+	case e.File != "":
+		where = fmt.Sprintf(" (%s)", e.File)
+	case e.Line > 0:
+		where = fmt.Sprintf(" (line %d)", e.Line)
+	}
+	return fmt.Sprintf("entry %d%s: %s: %s", e.Entry, where, e.Field, e.Message)
+}
+
+// ValidationErrors holds every problem found by Config.Validate
+type ValidationErrors []*ValidationError
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, e := range v {
+		messages[i] = e.Error()
+	}
+	return "invalid config:\n" + strings.Join(messages, "\n")
+}
+
+// sameListener reports whether two listen addresses of the same type conflict,
+// an empty or unspecified host conflicts with every host on the same port
+func sameListener(a, b string) bool {
+	hostA, portA, errA := net.SplitHostPort(a)
+	hostB, portB, errB := net.SplitHostPort(b)
+	if errA != nil || errB != nil || portA != portB {
+		return false
+	}
+	unspecified := func(host string) bool {
+		ip := net.ParseIP(host)
+		return host == "" || (ip != nil && ip.IsUnspecified())
+	}
+	return hostA == hostB || unspecified(hostA) || unspecified(hostB)
+}
+
+func validAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+		return fmt.Errorf("invalid port '%s'", port)
+	}
+	return nil
+}
+
+func validateBackends(backends []*Backend, connType, field string, add func(field, format string, args ...interface{})) {
+	if len(backends) == 0 {
+		add(field, "no backends")
+	}
+	for j, b := range backends {
+		field := fmt.Sprintf("%s[%d]", field, j)
+		if b == nil {
+			add(field, "empty backend")
+			continue
+		}
+		if err := validAddr(b.Addr); err != nil {
+			add(field+".Addr", "%v", err)
+		}
+		if b.Weight < 0 {
+			add(field+".Weight", "must not be negative")
+		}
+		if b.Priority < 0 {
+			add(field+".Priority", "must not be negative")
+		}
+		if b.TLS != nil {
+			validateBackendTLS(b.TLS, connType, field+".TLS", add)
+		}
+	}
+}
+
+// validateBalancer builds the balancer of entry so that the errors of its
+// factory, such as bad BalancerOptions, are found before the config is used
+func validateBalancer(e *Entry, backends []*Backend, field string, add func(field, format string, args ...interface{})) {
+	if !BalancerRegistered(e.Backend) || len(backends) == 0 {
+		return
+	}
+	for _, b := range backends {
+		if b == nil {
+			return
+		}
+	}
+	if _, err := newBalancer(e, backends); err != nil {
+		add(field, "%v", err)
+	}
+}
+
+func validServerName(pattern string) bool {
+	name := strings.TrimPrefix(pattern, "*.")
+	return name != "" && !strings.ContainsAny(name, "*/: ") && !strings.HasPrefix(name, ".") && !strings.HasSuffix(name, ".")
+}
+
+func validateRoutes(e *Entry, add func(field, format string, args ...interface{})) {
+	if len(e.Routes) > 0 && e.CertFile == "" && !e.SNIPassthrough {
+		add("Routes", "routes need CertFile and KeyFile or SNIPassthrough")
+	}
+	seen := make(map[string]int)
+	for i, r := range e.Routes {
+		field := fmt.Sprintf("Routes[%d]", i)
+		if r == nil {
+			add(field, "empty route")
+			continue
+		}
+		if len(r.ServerNames) == 0 {
+			add(field+".ServerNames", "no server names")
+		}
+		if len(r.ALPN) > 0 && !e.SNIPassthrough {
+			add(field+".ALPN", "ALPN is only supported with SNIPassthrough")
+		}
+		for _, name := range r.ServerNames {
+			key := routeKey([]string{name}, r.ALPN)
+			if !validServerName(name) {
+				add(field+".ServerNames", "invalid server name '%s'", name)
+			} else if j, ok := seen[key]; ok {
+				add(field+".ServerNames", "'%s' is already used by route %d", name, j)
+			}
+			seen[key] = i
+		}
+		if r.Backend != "" && !BalancerRegistered(r.Backend) {
+			add(field+".Backend", "unknown balancer '%s'", r.Backend)
+		}
+		validateBackends(r.Backends, e.Type, field+".Backends", add)
+		// a route without its own balancer is checked with the entry
+		if r.Backend != "" || len(e.Backends) == 0 {
+			validateBalancer(routeEntry(e, r), r.Backends, field+".BalancerOptions", add)
+		}
+		if r.CertFile != "" && e.SNIPassthrough {
+			add(field+".CertFile", "certificates are not used with SNIPassthrough")
+		} else if (r.CertFile == "") != (r.KeyFile == "") {
+			add(field+".CertFile", "CertFile and KeyFile must be set together")
+		} else if r.CertFile != "" {
+			if _, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile); err != nil {
+				add(field+".CertFile", "%v", err)
+			}
+		}
+	}
+}
+
+func validateClientAuth(e *Entry, add func(field, format string, args ...interface{})) {
+	c := e.ClientAuth
+	if e.CertFile == "" {
+		add("ClientAuth", "client certificates need CertFile and KeyFile")
+	}
+	if _, ok := clientAuthModes[c.Mode]; !ok {
+		add("ClientAuth.Mode", "unknown mode '%s'", c.Mode)
+		return
+	}
+	if c.verifies() && c.CAFile == "" {
+		add("ClientAuth.CAFile", "required to verify client certificates")
+	}
+	if !c.verifies() && (len(c.AllowedCNs) > 0 || len(c.AllowedSANs) > 0) {
+		add("ClientAuth.Mode", "allowed names need verified client certificates")
+	}
+	if _, err := c.compile(); err != nil {
+		add("ClientAuth.CAFile", "%v", err)
+	}
+}
+
+func validateBackendTLS(t *BackendTLS, connType, field string, add func(field, format string, args ...interface{})) {
+	if !t.Enabled {
+		return
+	}
+	if connType == "udp" {
+		add(field, "TLS is not supported for udp")
+		return
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		add(field+".CertFile", "CertFile and KeyFile must be set together")
+		return
+	}
+	if _, err := t.config(); err != nil {
+		add(field, "%v", err)
+	}
+}
+
+// Validate checks the config after defaults have been applied and returns
+// ValidationErrors listing all of its problems, or nil
+func (c *Config) Validate() error {
+	var errs ValidationErrors
+	for i, e := range c.Entries {
+		var l location
+		if i < len(c.locations) {
+			l = c.locations[i]
+		}
+		add := func(field, format string, args ...interface{}) {
+			errs = append(errs, &ValidationError{Entry: i, File: l.file, Line: l.line, Field: field, Message: fmt.Sprintf(format, args...)})
+		}
+		if e == nil {
+			add("Entries", "empty entry")
+			continue
+		}
+
+		if e.ListenAddr == "" {
+			add("ListenAddr", "missing")
+		} else if err := validAddr(e.ListenAddr); err != nil {
+			add("ListenAddr", "%v", err)
+		} else {
+			for j, other := range c.Entries[:i] {
+				if other != nil && other.Type == e.Type && sameListener(other.ListenAddr, e.ListenAddr) {
+					add("ListenAddr", "'%s' conflicts with entry %d", e.ListenAddr, j)
+					break
+				}
+			}
+		}
+		if e.Type != "tcp" && e.Type != "udp" {
+			add("Type", "unknown type '%s'", e.Type)
+		}
+		if e.Timeout < 0 {
+			add("Timeout", "must not be negative")
+		}
+		if e.DrainTimeout < 0 {
+			add("DrainTimeout", "must not be negative")
+		}
+		if e.SlowStart < 0 {
+			add("SlowStart", "must not be negative")
+		}
+		if !BalancerRegistered(e.Backend) {
+			add("Backend", "unknown balancer '%s'", e.Backend)
+		}
+		if e.VirtualNodes < 0 {
+			add("VirtualNodes", "must not be negative")
+		}
+		if e.TableSize < 0 {
+			add("TableSize", "must not be negative")
+		} else if e.TableSize > MaxTableSize {
+			add("TableSize", "must not be more than %d", MaxTableSize)
+		}
+		switch e.HashKey {
+		case "", "source-ip", "source-ip-port", "sni":
+		default:
+			add("HashKey", "unknown key '%s'", e.HashKey)
+		}
+
+		// connections that match no route are rejected when there are no
+		// default backends
+		if len(e.Backends) > 0 || len(e.Routes) == 0 {
+			validateBackends(e.Backends, e.Type, "Backends", add)
+		}
+		validateBalancer(e, e.Backends, "BalancerOptions", add)
+		validateRoutes(e, add)
+
+		if e.SNIPassthrough {
+			if e.Type != "tcp" {
+				add("SNIPassthrough", "only supported for tcp")
+			}
+			if e.CertFile != "" {
+				add("SNIPassthrough", "CertFile must not be set, TLS is not terminated")
+			}
+		}
+
+		if (e.CertFile == "") != (e.KeyFile == "") {
+			add("CertFile", "CertFile and KeyFile must be set together")
+		}
+		if e.CertFile != "" && e.Type == "udp" {
+			add("CertFile", "TLS is not supported for udp")
+		}
+		if e.CertFile != "" && e.KeyFile != "" {
+			if _, err := tls.LoadX509KeyPair(e.CertFile, e.KeyFile); err != nil {
+				add("CertFile", "%v", err)
+			}
+		}
+
+		if e.BackendTLS != nil {
+			validateBackendTLS(e.BackendTLS, e.Type, "BackendTLS", add)
+		}
+		if e.ClientAuth != nil {
+			validateClientAuth(e, add)
+		}
+		if e.ProxyProtocol && e.Type != "tcp" {
+			add("ProxyProtocol", "only supported for tcp")
+		}
+		if e.AccessLog && e.Type != "tcp" {
+			add("AccessLog", "only supported for tcp")
+		}
+
+		if hc := e.HealthCheck; hc != nil {
+			switch hc.Type {
+			case "tcp", "http":
+				if e.Type == "udp" {
+					add("HealthCheck.Type", "'%s' checks are not supported for udp", hc.Type)
+				}
+			case "send-expect":
+			default:
+				add("HealthCheck.Type", "unknown type '%s'", hc.Type)
+			}
+			if hc.Interval <= 0 {
+				add("HealthCheck.Interval", "must be positive")
+			}
+			if hc.Timeout <= 0 {
+				add("HealthCheck.Timeout", "must be positive")
+			}
+			if hc.Rise <= 0 {
+				add("HealthCheck.Rise", "must be positive")
+			}
+			if hc.Fall <= 0 {
+				add("HealthCheck.Fall", "must be positive")
+			}
+			if hc.Type == "http" && !strings.HasPrefix(hc.Path, "/") {
+				add("HealthCheck.Path", "must start with /")
+			}
+		}
+
+		if od := e.OutlierDetection; od != nil {
+			if od.ConsecutiveFailures <= 0 {
+				add("OutlierDetection.ConsecutiveFailures", "must be positive")
+			}
+			if od.BaseEjectionTime <= 0 {
+				add("OutlierDetection.BaseEjectionTime", "must be positive")
+			}
+			if od.MaxEjectionTime < od.BaseEjectionTime {
+				add("OutlierDetection.MaxEjectionTime", "must not be less than BaseEjectionTime")
+			}
+			if od.MaxEjectionPercent < 0 || od.MaxEjectionPercent > 100 {
+				add("OutlierDetection.MaxEjectionPercent", "must be between 0 and 100")
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
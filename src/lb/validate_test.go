@@ -0,0 +1,66 @@
+package lb
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValidateBalancerOptions(t *testing.T) {
+	backends := func() []*Backend { return []*Backend{{Addr: "127.0.0.1:8000", Weight: 1}} }
+	tests := []struct {
+		name  string
+		entry *Entry
+		field string
+	}{
+		{"valid", &Entry{Backend: "PeakEWMA", BalancerOptions: json.RawMessage(`{"Decay": 5}`), Backends: backends()}, ""},
+		{"entry", &Entry{Backend: "PeakEWMA", BalancerOptions: json.RawMessage(`{"Decay": "x"}`), Backends: backends()}, "BalancerOptions"},
+		{"route", &Entry{Backend: "RoundRobin", Backends: backends(), SNIPassthrough: true, Routes: []*Route{
+			{ServerNames: []string{"a.example.com"}, Backend: "PeakEWMA", BalancerOptions: json.RawMessage(`{"Decay": "x"}`), Backends: backends()},
+		}}, "Routes[0].BalancerOptions"},
+		{"inherited", &Entry{Backend: "PeakEWMA", BalancerOptions: json.RawMessage(`{"Decay": "x"}`), SNIPassthrough: true, Routes: []*Route{
+			{ServerNames: []string{"a.example.com"}, Backends: backends()},
+		}}, "Routes[0].BalancerOptions"},
+	}
+	for _, test := range tests {
+		test.entry.ListenAddr = "127.0.0.1:9000"
+		test.entry.Type = "tcp"
+		err := (&Config{Entries: []*Entry{test.entry}}).Validate()
+		if test.field == "" {
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			}
+			continue
+		}
+		errs, ok := err.(ValidationErrors)
+		if !ok || len(errs) != 1 || errs[0].Field != test.field {
+			t.Errorf("%s: got %v, want an error for %s", test.name, err, test.field)
+		}
+	}
+}
+
+func TestValidateHealthCheck(t *testing.T) {
+	tests := []struct {
+		check *HealthCheck
+		field string
+	}{
+		{&HealthCheck{Type: "tcp", Interval: 1, Timeout: 1, Rise: 1, Fall: 1}, ""},
+		{&HealthCheck{Type: "tcp", Interval: 1, Timeout: -1, Rise: 1, Fall: 1}, "HealthCheck.Timeout"},
+		{&HealthCheck{Type: "tcp", Interval: -1, Timeout: 1, Rise: 1, Fall: 1}, "HealthCheck.Interval"},
+		{&HealthCheck{Type: "http", Interval: 1, Timeout: 1, Rise: 1, Fall: 1, Path: "health"}, "HealthCheck.Path"},
+		{&HealthCheck{Type: "ping", Interval: 1, Timeout: 1, Rise: 1, Fall: 1}, "HealthCheck.Type"},
+	}
+	for _, test := range tests {
+		entry := &Entry{ListenAddr: "127.0.0.1:9000", Type: "tcp", Backend: "RoundRobin", Backends: []*Backend{{Addr: "127.0.0.1:8000", Weight: 1}}, HealthCheck: test.check}
+		err := (&Config{Entries: []*Entry{entry}}).Validate()
+		if test.field == "" {
+			if err != nil {
+				t.Errorf("%+v: %v", test.check, err)
+			}
+			continue
+		}
+		errs, ok := err.(ValidationErrors)
+		if !ok || len(errs) != 1 || errs[0].Field != test.field {
+			t.Errorf("%+v: got %v, want an error for %s", test.check, err, test.field)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package lb
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	MaxCheckBackoff = 300 // seconds
+	// ConfigSettleTime is how long to wait after a file event before reading
+	// the config, editors and configmap updates touch several files at once
+	ConfigSettleTime = 200 * time.Millisecond
+)
+
+// configWatcher sends SIGHUP to the Manager when its config or one of the
+// configs it includes changes. The directories of local configs are watched
+// with fsnotify so that replacing a file by renaming another over it, as
+// editors and kubernetes configmaps do, is seen. URLs, and files when fsnotify
+// is unavailable, are polled every CheckInterval seconds, backing off on
+// errors.
+type configWatcher struct {
+	sync.Mutex
+	path      string
+	fetcher   *configFetcher
+	notify    chan os.Signal
+	fs        *fsnotify.Watcher
+	dirs      map[string]bool
+	hash      string
+	interval  time.Duration
+	poll      bool
+	lastError string
+	update    chan bool
+	stop      chan bool
+	once      sync.Once
+}
+
+func newConfigWatcher(path string, fetcher *configFetcher, notify chan os.Signal) *configWatcher {
+	w := &configWatcher{
+		path:     path,
+		fetcher:  fetcher,
+		notify:   notify,
+		dirs:     make(map[string]bool),
+		interval: CheckInterval * time.Second,
+		update:   make(chan bool, 1),
+		stop:     make(chan bool),
+	}
+	fs, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("config watcher: polling for changes: %v", err)
+	} else {
+		w.fs = fs
+	}
+	return w
+}
+
+// setConfig records the config in effect so that only later changes are
+// reported, and watches the directories of its files
+func (w *configWatcher) setConfig(config *Config) {
+	w.Lock()
+	defer w.Unlock()
+	w.hash = sourcesHash(config.sources)
+	if config.Source != w.path {
+		// a cached config is replaced as soon as the config loads again
+		w.hash = ""
+	}
+	if config.CheckInterval > 0 {
+		w.interval = time.Duration(config.CheckInterval) * time.Second
+	}
+
+	w.poll = w.fs == nil
+	dirs := make(map[string]bool)
+	for _, s := range config.sources {
+		if httpURL.MatchString(s.location) {
+			w.poll = true
+			continue
+		}
+		dirs[filepath.Dir(s.location)] = true
+		if target, err := filepath.EvalSymlinks(s.location); err == nil {
+			dirs[filepath.Dir(target)] = true
+		}
+		for _, glob := range s.globs {
+			if dir := filepath.Dir(glob); !strings.ContainsAny(dir, "*?[") {
+				dirs[dir] = true
+			}
+		}
+	}
+	if w.fs != nil {
+		for dir := range w.dirs {
+			if !dirs[dir] {
+				w.fs.Remove(dir)
+				delete(w.dirs, dir)
+			}
+		}
+		for dir := range dirs {
+			if w.dirs[dir] {
+				continue
+			}
+			if err := w.fs.Add(dir); err != nil {
+				log.Printf("config watcher: polling for changes: %v", err)
+				w.poll = true
+				continue
+			}
+			w.dirs[dir] = true
+		}
+	}
+
+	select {
+	case w.update <- true:
+	default:
+	}
+}
+
+// nextPoll returns when to poll next, nil if the config is not polled
+func (w *configWatcher) nextPoll(failures int) <-chan time.Time {
+	w.Lock()
+	defer w.Unlock()
+	if !w.poll {
+		return nil
+	}
+	delay := w.interval
+	for i := 0; i < failures && delay < MaxCheckBackoff*time.Second; i++ {
+		delay *= 2
+	}
+	if delay > MaxCheckBackoff*time.Second {
+		delay = MaxCheckBackoff * time.Second
+	}
+	return time.After(delay)
+}
+
+func (w *configWatcher) run() {
+	var events chan fsnotify.Event
+	var errors chan error
+	if w.fs != nil {
+		events, errors = w.fs.Events, w.fs.Errors
+	}
+
+	var settle <-chan time.Time
+	failures := 0
+	poll := w.nextPoll(failures)
+	for {
+		select {
+		case <-w.stop:
+			if w.fs != nil {
+				w.fs.Close()
+			}
+			return
+		case <-events:
+			settle = time.After(ConfigSettleTime)
+		case err := <-errors:
+			log.Println("config watcher:", err)
+		case <-w.update:
+			failures = 0
+			poll = w.nextPoll(failures)
+		case <-settle:
+			settle = nil
+			w.check()
+		case <-poll:
+			if err := w.check(); err != nil {
+				failures++
+			} else {
+				failures = 0
+			}
+			poll = w.nextPoll(failures)
+		}
+	}
+}
+
+// Stop ends run, the config is no longer checked for changes
+func (w *configWatcher) Stop() {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+}
+
+// check reads the config and signals the Manager if it changed. Configs that
+// fail to load are logged once and picked up when they load again.
+func (w *configWatcher) check() error {
+	sources, err := loadSources(w.path, nil, w.fetcher)
+	if err != nil {
+		if err.Error() != w.lastError {
+			log.Printf("config check failed: [%v] %v", w.path, err)
+		}
+		w.lastError = err.Error()
+		return err
+	}
+	w.lastError = ""
+
+	hash := sourcesHash(sources)
+	w.Lock()
+	changed := hash != w.hash
+	w.hash = hash
+	w.Unlock()
+	if changed {
+		log.Printf("config change detected: [%v]", w.path)
+		w.notify <- syscall.SIGHUP
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package lb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchRenameReplace(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	writeConfig(t, path, "127.0.0.1:8080", "127.0.0.1:9000")
+	fetcher, err := newConfigFetcher(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadConfig(path, fetcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notify := make(chan os.Signal, 1)
+	w := newConfigWatcher(path, fetcher, notify)
+	if w.fs == nil {
+		t.Skip("no file system notifications")
+	}
+	w.setConfig(config)
+	go w.run()
+	defer w.Stop()
+
+	// editors and configmaps replace the file, the watch has to survive each
+	// replacement
+	for i, backend := range []string{"127.0.0.1:9001", "127.0.0.1:9002"} {
+		tmp := filepath.Join(dir, ".config.json.tmp")
+		writeConfig(t, tmp, "127.0.0.1:8080", backend)
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-notify:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("replacement %d not detected", i+1)
+		}
+	}
+}
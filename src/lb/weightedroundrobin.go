@@ -0,0 +1,76 @@
+package lb
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// WeightedRoundRobin uses the smooth weighted round robin algorithm so that
+// a heavy backend is interleaved with the others rather than being picked
+// several times in a row.
+type WeightedRoundRobin struct {
+	sync.Mutex
+	Backends []*Backend
+	current  []float64
+}
+
+func NewWeightedRoundRobin(backends []*Backend) *WeightedRoundRobin {
+	return &WeightedRoundRobin{
+		Backends: backends,
+		current:  make([]float64, len(backends)),
+	}
+}
+
+func (w *WeightedRoundRobin) NextBackend(c net.Conn) (*Backend, error) {
+	return w.NextBackendExcluding(c, nil)
+}
+
+func (w *WeightedRoundRobin) NextBackendExcluding(c net.Conn, exclude map[*Backend]bool) (*Backend, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	best := -1
+	total := 0.0
+	for i, b := range w.Backends {
+		if !usable(b, exclude) {
+			continue
+		}
+		weight := b.effectiveWeight()
+		w.current[i] += weight
+		total += weight
+		if best == -1 || w.current[i] > w.current[best] {
+			best = i
+		}
+	}
+
+	if best == -1 {
+		return nil, ErrNoBackend
+	}
+	w.current[best] -= total
+	return w.Backends[best], nil
+}
+
+func (w *WeightedRoundRobin) Stats() string {
+	w.Lock()
+	defer w.Unlock()
+	var out bytes.Buffer
+	out.WriteString("\n")
+	for i, b := range w.Backends {
+		fmt.Fprintf(&out, "%v weight: %v effective: %.2f current: %.2f\n", b.Addr, b.Weight, b.effectiveWeight(), w.current[i])
+	}
+	return out.String()
+}
+
+func (w *WeightedRoundRobin) Name() string {
+	return "WeightedRoundRobin"
+}
+
+func (w *WeightedRoundRobin) HandleStarted(c net.Conn) {
+	// do nothing
+}
+
+func (w *WeightedRoundRobin) HandleDone(c net.Conn) {
+	// do nothing
+}
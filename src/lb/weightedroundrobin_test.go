@@ -0,0 +1,54 @@
+package lb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWeightedRoundRobinSequence(t *testing.T) {
+	tests := []struct {
+		weights []int
+		want    string
+	}{
+		// smooth: the heavy backend is spread out rather than picked 5 times in a row
+		{[]int{5, 1, 1}, "a a b a c a a a a b a c a a"},
+		{[]int{1, 1, 1}, "a b c a b c"},
+		{[]int{2, 1}, "a b a a b a"},
+	}
+	names := map[string]string{"10.0.0.1:80": "a", "10.0.0.2:80": "b", "10.0.0.3:80": "c"}
+	for _, test := range tests {
+		backends := testBackends(len(test.weights))
+		for i, w := range test.weights {
+			backends[i].Weight = w
+		}
+		w := NewWeightedRoundRobin(backends)
+		var got []string
+		for range strings.Fields(test.want) {
+			b, err := w.NextBackend(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, names[b.Addr])
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("weights %v: got %s, want %s", test.weights, strings.Join(got, " "), test.want)
+		}
+	}
+}
+
+func TestWeightedRoundRobinSkipsUnavailable(t *testing.T) {
+	backends := testBackends(3)
+	backends[0].Weight = 5
+	backends[0].setUp(false)
+	w := NewWeightedRoundRobin(backends)
+	exclude := map[*Backend]bool{backends[1]: true}
+	for i := 0; i < 5; i++ {
+		if b, _ := w.NextBackendExcluding(nil, exclude); b != backends[2] {
+			t.Fatalf("got %v, want %s", b, backends[2].Addr)
+		}
+	}
+	exclude[backends[2]] = true
+	if _, err := w.NextBackendExcluding(nil, exclude); err != ErrNoBackend {
+		t.Errorf("got %v, want ErrNoBackend", err)
+	}
+}